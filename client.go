@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"cube/internal"
+	"cube/internal/model"
+	"cube/internal/util"
+)
+
+// 通过运行中服务的 /source 接口读写源码，用于命令行的 -server 参数
+type remoteStore struct {
+	server   string // 服务地址，如 http://127.0.0.1:8090
+	userpass string // 服务中账号的 <username:password>
+	token    string // 服务中的 API 令牌，指定时不再登录
+	client   *http.Client
+}
+
+func newRemoteStore(server string, userpass string, token string) *remoteStore {
+	jar, _ := cookiejar.New(nil) // 保存登录后的会话 cookie
+	return &remoteStore{server: strings.TrimSuffix(server, "/"), userpass: userpass, token: token, client: &http.Client{Jar: jar}}
+}
+
+// 发送请求并解析响应中的 data 字段，未指定令牌时在首次请求前先登录
+func (s *remoteStore) request(method string, path string, body interface{}, data interface{}) error {
+	if s.userpass != "" && s.token == "" {
+		name, password, _ := strings.Cut(s.userpass, ":")
+		s.userpass = ""
+		if err := s.request(http.MethodPost, "/user?login", map[string]string{"name": name, "password": password}, nil); err != nil {
+			return errors.New("login failed: " + err.Error())
+		}
+	}
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, s.server+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		if s.token != "" {
+			return errors.New("invalid or expired token")
+		}
+		return errors.New("login required, set -user to the <username:password> of an account, or -token to an api token")
+	}
+
+	var result struct {
+		Code    string          `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &result); err != nil {
+		return errors.New(resp.Status + ": " + strings.TrimSpace(string(b)))
+	}
+	if result.Code != "0" {
+		return errors.New(result.Message)
+	}
+	if data != nil && len(result.Data) > 0 {
+		return json.Unmarshal(result.Data, data)
+	}
+	return nil
+}
+
+func (s *remoteStore) query(values url.Values) ([]model.Source, error) {
+	values.Set("content", "")
+	values.Set("size", "1000000")
+	values.Set("sort", "name asc")
+	var data struct {
+		Sources []model.Source `json:"sources"`
+	}
+	if err := s.request(http.MethodGet, "/source?"+values.Encode(), nil, &data); err != nil {
+		return nil, err
+	}
+	return data.Sources, nil
+}
+
+func (s *remoteStore) Sources() ([]model.Source, error) {
+	return s.query(url.Values{})
+}
+
+func (s *remoteStore) Get(name string, stype string) (model.Source, error) {
+	sources, err := s.query(url.Values{"name": {name}, "type": {stype}})
+	if err != nil {
+		return model.Source{}, err
+	}
+	for _, source := range sources { // 查询条件为模糊匹配，如 "_" 匹配任意字符
+		if source.Name == name && source.Type == stype {
+			return source, nil
+		}
+	}
+	return model.Source{}, internal.ErrSourceNotExist
+}
+
+func (s *remoteStore) Save(source model.Source, current *model.Source) (util.Time, error) {
+	// 在本地执行与 DbStore 相同的校验，以免创建后启用失败时留下半成品
+	if err := internal.CheckSourceSave(source, current); err != nil {
+		return util.Time{}, err
+	}
+	if current == nil {
+		// 接口不支持在创建时直接启用，先以停用状态创建
+		active := source.Active
+		source.Active = false
+		if err := s.request(http.MethodPost, "/source", source, nil); err != nil {
+			return util.Time{}, err
+		}
+		if active {
+			if err := s.SetActive(source.Name, source.Type, true, false); err != nil {
+				return util.Time{}, err
+			}
+		}
+		created, err := s.Get(source.Name, source.Type)
+		return created.LastModifiedDate, err
+	}
+
+	record := map[string]interface{}{
+		"name":               source.Name,
+		"type":               source.Type,
+		"content":            source.Content,
+		"compiled":           "", // 由服务端重新编译
+		"method":             source.Method,
+		"url":                source.Url,
+		"cron":               source.Cron,
+		"timeout":            source.Timeout,
+		"overlap":            source.Overlap,
+		"retries":            source.Retries,
+		"restart":            source.Restart,
+		"tag":                source.Tag,
+		"last_modified_date": current.LastModifiedDate.String(), // 版本号不一致时服务端拒绝修改
+	}
+	if source.Active != current.Active { // 启停须具备 publisher 角色，仅在变化时修改
+		record["active"] = source.Active
+		if source.Type == "daemon" {
+			record["status"] = strconv.FormatBool(source.Active) // 随启用状态启停 daemon
+		}
+	}
+	var data struct {
+		LastModifiedDate util.Time `json:"last_modified_date"`
+	}
+	err := s.request(http.MethodPut, "/source", record, &data)
+	return data.LastModifiedDate, err
+}
+
+func (s *remoteStore) SetActive(name string, stype string, active bool, force bool) error {
+	record := map[string]interface{}{"name": name, "type": stype, "active": active}
+	if stype == "daemon" {
+		record["status"] = strconv.FormatBool(active)
+	}
+	return s.request(http.MethodPut, "/source?force="+strconv.FormatBool(force), record, nil)
+}
+
+func (s *remoteStore) Delete(name string, stype string, force bool) error {
+	return s.request(http.MethodDelete, "/source?"+url.Values{"name": {name}, "type": {stype}, "force": {strconv.FormatBool(force)}}.Encode(), nil, nil)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"cube/internal"
+	"cube/internal/cache"
+	"cube/internal/log"
+	"cube/internal/model"
+)
+
+const usage = `Usage: cube [flags] <command> [arguments]
+
+Commands:
+  list [-type <type>] [-name <text>]     list the sources
+  get [-json] <type> <name>              print the content of a source, or the whole source with -json
+  put [flags] <type> <name> [file|-]     create or update a source from a file or stdin, see cube put -h for the flags
+  activate <type> <name>                 activate a source
+  deactivate [-force] <type> <name>      deactivate a source, refused while active sources import it unless -force
+  delete [-force] <type> <name>          delete a source, refused while active sources import it unless -force
+  export [-force] <dir>                  export the sources to a directory, e.g. controller/foo.ts with controller/foo.meta.json
+  import [-force] <dir>                  import the changed sources from a directory exported by export
+  run <file|name> [args]                 run a script file or an active module in a single worker and print the result
+
+The commands except run operate on ./cube.db, or on a running instance with -server <url> and -user <username:password>
+or -token <api token> (defaults to $CUBE_SERVER, $CUBE_USER and $CUBE_TOKEN). Use -server when the instance on ./cube.db
+is running, so that it picks up the changes.
+
+Run cube -h for the flags of the server.
+`
+
+// 执行子命令，返回进程的退出码
+func runCommand(args []string) int {
+	switch args[0] {
+	case "list":
+		return runList(args[1:])
+	case "get":
+		return runGet(args[1:])
+	case "put":
+		return runPut(args[1:])
+	case "activate", "deactivate", "delete":
+		return runChange(args[0], args[1:])
+	case "export", "import":
+		return runSync(args[0], args[1:])
+	case "run":
+		return runScript(args[1:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+}
+
+// 创建子命令的参数集，并添加 -server、-user、-token 参数，返回的方法在解析参数后创建读写源码的位置
+func newFlagSet(command string) (*flag.FlagSet, func() internal.SourceStore) {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	server := flags.String("server", os.Getenv("CUBE_SERVER"), "url of a running instance, e.g. http://127.0.0.1:8090, instead of ./cube.db")
+	account := flags.String("user", os.Getenv("CUBE_USER"), "<username:password> of an account of the running instance")
+	token := flags.String("token", os.Getenv("CUBE_TOKEN"), "api token of the running instance, used instead of -user")
+	return flags, func() internal.SourceStore {
+		if *server != "" {
+			return newRemoteStore(*server, *account, *token)
+		}
+		name := "cli"
+		if u, err := user.Current(); err == nil {
+			name += ":" + u.Username
+		}
+		openDb("./cube.db")
+		return &internal.DbStore{User: name}
+	}
+}
+
+// 打开数据库并初始化缓存，file 为空时使用内存数据库
+func openDb(file string) {
+	internal.InitDb(file)
+	if err := cache.Init(internal.Db); err != nil {
+		panic(err)
+	}
+}
+
+// 解析参数，参数个数不在 [min, max] 之间时打印用法，max 为 -1 时不限制
+func parseFlags(flags *flag.FlagSet, args []string, min int, max int) bool {
+	if err := flags.Parse(args); err != nil {
+		return false
+	}
+	if flags.NArg() < min || (max >= 0 && flags.NArg() > max) {
+		fmt.Fprint(os.Stderr, usage)
+		return false
+	}
+	return true
+}
+
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
+
+// 列出源码，按类型和名称排序
+func runList(args []string) int {
+	flags, store := newFlagSet("list")
+	stype := flags.String("type", "", "only list the sources of the type")
+	name := flags.String("name", "", "only list the sources whose name contains the text")
+	if !parseFlags(flags, args, 0, 0) {
+		return 2
+	}
+
+	sources, err := store().Sources()
+	if err != nil {
+		return fail(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tNAME\tLANG\tACTIVE\tROUTE\tTAG\tLAST MODIFIED")
+	for _, s := range sources {
+		if (*stype != "" && s.Type != *stype) || !strings.Contains(s.Name, *name) {
+			continue
+		}
+		route := ""
+		switch s.Type {
+		case "controller":
+			route = strings.TrimSpace(strings.ToUpper(s.Method) + " " + s.Url)
+		case "resource":
+			route = s.Url
+		case "crontab":
+			route = s.Cron
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%s\n", s.Type, s.Name, s.Lang, s.Active, route, s.Tag, s.LastModifiedDate.String())
+	}
+	w.Flush()
+	return 0
+}
+
+// 打印源码的内容
+func runGet(args []string) int {
+	flags, store := newFlagSet("get")
+	asJson := flags.Bool("json", false, "print the whole source as JSON")
+	if !parseFlags(flags, args, 2, 2) {
+		return 2
+	}
+
+	source, err := store().Get(flags.Arg(1), flags.Arg(0))
+	if err != nil {
+		return fail(err)
+	}
+	if !*asJson {
+		fmt.Print(source.Content)
+		return 0
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(source)
+	return 0
+}
+
+// 新增或修改源码，未指定的属性保持不变
+func runPut(args []string) int {
+	flags, store := newFlagSet("put")
+	lang := flags.String("lang", "", "language of the source: typescript, tsx, javascript, json, html, vue or text, defaults to the one of the file extension")
+	method := flags.String("method", "", "http method of the controller, empty for any")
+	url := flags.String("url", "", "url of the controller or resource")
+	cron := flags.String("cron", "", "cron expression of the crontab")
+	tag := flags.String("tag", "", "tags of the source")
+	active := flags.Bool("active", false, "activate or deactivate the source")
+	if !parseFlags(flags, args, 2, 3) {
+		return 2
+	}
+	stype, name, file := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+
+	var (
+		data []byte
+		err  error
+	)
+	if file == "" || file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	s := store()
+	var current *model.Source
+	source, err := s.Get(name, stype)
+	if err == nil {
+		current = &model.Source{}
+		*current = source
+	} else if !errors.Is(err, internal.ErrSourceNotExist) {
+		return fail(err)
+	} else {
+		source = model.Source{Name: name, Type: stype, Lang: defaultLang(stype), Overlap: "allow", Restart: "never"}
+	}
+	source.Content = string(data)
+	if l := fileLang(file); l != "" {
+		source.Lang = l
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "lang":
+			source.Lang = *lang
+		case "method":
+			source.Method = *method
+		case "url":
+			source.Url = *url
+		case "cron":
+			source.Cron = *cron
+		case "tag":
+			source.Tag = *tag
+		case "active":
+			source.Active = *active
+		}
+	})
+
+	if _, err := s.Save(source, current); err != nil {
+		return fail(err)
+	}
+	if current == nil {
+		fmt.Printf("created %s/%s\n", stype, name)
+	} else {
+		fmt.Printf("updated %s/%s\n", stype, name)
+	}
+	return 0
+}
+
+// 新建源码的默认语言，与 IDE 一致
+func defaultLang(stype string) string {
+	if stype == "template" || stype == "resource" {
+		return "html"
+	}
+	return "typescript"
+}
+
+// 根据文件的扩展名获取语言，未知时返回空字符串
+func fileLang(file string) string {
+	switch filepath.Ext(file) {
+	case ".ts":
+		return "typescript"
+	case ".tsx":
+		return "tsx"
+	case ".js", ".cjs":
+		return "javascript"
+	case ".json":
+		return "json"
+	case ".html":
+		return "html"
+	case ".vue":
+		return "vue"
+	case ".txt":
+		return "text"
+	}
+	return ""
+}
+
+// 启用、停用或删除源码
+func runChange(command string, args []string) int {
+	flags, store := newFlagSet(command)
+	force := flags.Bool("force", false, "deactivate or delete the source even if active sources still import it")
+	if !parseFlags(flags, args, 2, 2) {
+		return 2
+	}
+	stype, name := flags.Arg(0), flags.Arg(1)
+
+	var err error
+	switch command {
+	case "activate":
+		err = store().SetActive(name, stype, true, false)
+	case "deactivate":
+		err = store().SetActive(name, stype, false, *force)
+	case "delete":
+		err = store().Delete(name, stype, *force)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("%s %s/%s\n", map[string]string{"activate": "activated", "deactivate": "deactivated", "delete": "deleted"}[command], stype, name)
+	return 0
+}
+
+// 在数据库与目录之间同步源码，存在冲突或错误时退出码为 1
+func runSync(command string, args []string) int {
+	flags, store := newFlagSet(command)
+	force := flags.Bool("force", false, "overwrite the sources modified on the other side since the last sync")
+	if !parseFlags(flags, args, 1, 1) {
+		return 2
+	}
+
+	var (
+		results []model.SyncResult
+		err     error
+	)
+	if command == "export" {
+		results, err = internal.ExportSources(store(), flags.Arg(0), *force)
+	} else {
+		results, err = internal.ImportSources(store(), flags.Arg(0), *force)
+	}
+
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.Action]++
+		if r.Action == "unchanged" {
+			continue
+		}
+		if r.Message != "" {
+			fmt.Printf("%-9s %s: %s\n", r.Action, r.Path, r.Message)
+		} else {
+			fmt.Printf("%-9s %s\n", r.Action, r.Path)
+		}
+	}
+	if err != nil {
+		return fail(err)
+	}
+	fmt.Printf("%d created, %d updated, %d unchanged, %d skipped, %d conflicts, %d errors\n", counts["created"], counts["updated"], counts["unchanged"], counts["skipped"], counts["conflict"], counts["error"])
+	if counts["conflict"] > 0 || counts["error"] > 0 {
+		return 1
+	}
+	return 0
+}
+
+// 执行脚本并打印结果：字符串原样输出，其余输出为 JSON；脚本中的日志同时输出到 stderr
+func runScript(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	log.Echo(os.Stderr)
+	defer log.Echo(nil)
+
+	// 从 ./cube.db 中加载模块，不存在时使用内存数据库，以免在当前目录中创建数据库
+	file := "./cube.db"
+	if _, err := os.Stat(file); err != nil {
+		file = ""
+	}
+	openDb(file)
+
+	value, err := internal.RunScript(args[0], args[1:])
+	if err != nil {
+		var exception interface{ String() string }
+		if errors.As(err, &exception) { // js 异常包含堆栈
+			return fail(errors.New(exception.String()))
+		}
+		return fail(err)
+	}
+	switch v := value.(type) {
+	case nil:
+	case string:
+		fmt.Println(v)
+	case []byte:
+		os.Stdout.Write(v)
+	default:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return fail(err)
+		}
+	}
+	return 0
+}
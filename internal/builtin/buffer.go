@@ -1,12 +1,19 @@
 package builtin
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"math"
+	"math/big"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/dop251/goja"
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 func init() {
@@ -17,19 +24,88 @@ func init() {
 			return runtime.ToValue(&Buffer{}).ToObject(runtime)
 		}).ToObject(runtime)
 
-		o.Set("from", func(input []byte, encoding string) (*Buffer, error) {
+		o.Set("from", func(input []byte, encoding string) (*Buffer, error) { // 入参如果是 Uint8Array 或 ArrayBuffer，goja 将直接引用其底层的 []byte，不会产生拷贝
 			dat, err := decode(input, encoding)
 			return (*Buffer)(&dat), err
 		})
 
+		o.Set("alloc", func(size int, fill interface{}, encoding string) (*Buffer, error) {
+			if size < 0 {
+				return nil, errors.New("size out of range")
+			}
+			b := make(Buffer, size)
+			if fill != nil {
+				if _, err := b.Fill(fill, runtime.ToValue(0), runtime.ToValue(size), runtime.ToValue(encoding)); err != nil {
+					return nil, err
+				}
+			}
+			return &b, nil
+		})
+
+		o.Set("allocUnsafe", func(size int) (*Buffer, error) {
+			if size < 0 {
+				return nil, errors.New("size out of range")
+			}
+			b := make(Buffer, size)
+			return &b, nil
+		})
+
+		o.Set("concat", func(list [][]byte, length goja.Value) *Buffer {
+			total := 0
+			for _, v := range list {
+				total += len(v)
+			}
+			if length != nil && !goja.IsUndefined(length) && !goja.IsNull(length) {
+				total = int(length.ToInteger())
+			}
+			b := make(Buffer, total)
+			offset := 0
+			for _, v := range list {
+				if offset >= total {
+					break
+				}
+				offset += copy(b[offset:], v)
+			}
+			return &b
+		})
+
+		o.Set("compare", func(a []byte, b []byte) int {
+			return bytes.Compare(a, b)
+		})
+
+		o.Set("isBuffer", func(value interface{}) bool {
+			switch value.(type) {
+			case Buffer, *Buffer:
+				return true
+			}
+			return false
+		})
+
+		o.Set("isEncoding", func(encoding string) bool {
+			_, err := encode(nil, encoding)
+			return err == nil
+		})
+
+		o.Set("byteLength", func(input interface{}, encoding string) (int, error) {
+			b, err := toBytes(input, encoding)
+			return len(b), err
+		})
+
+		o.Set("toUint8Array", func(input []byte) (*goja.Object, error) { // 与 Buffer 共享同一块内存，不会产生拷贝
+			return runtime.New(runtime.Get("Uint8Array"), runtime.ToValue(runtime.NewArrayBuffer(input)))
+		})
+
 		runtime.Set("Buffer", o)
 	})
 }
 
 type Buffer []byte
 
-func (b *Buffer) ToString(encoding string) (string, error) {
-	return encode(*b, encoding)
+//#region 转换
+
+func (b *Buffer) ToString(encoding string, params ...int) (string, error) {
+	start, end := b.bounds(params...)
+	return encode((*b)[start:end], encoding)
 }
 
 func (b *Buffer) ToJson() (obj interface{}, err error) {
@@ -37,9 +113,439 @@ func (b *Buffer) ToJson() (obj interface{}, err error) {
 	return
 }
 
+//#endregion
+
+//#region 比较、查找
+
+func (b *Buffer) Equals(target []byte) bool {
+	return bytes.Equal(*b, target)
+}
+
+func (b *Buffer) Compare(target []byte) int {
+	return bytes.Compare(*b, target)
+}
+
+func (b *Buffer) IndexOf(value interface{}, offset int, encoding string) (int, error) {
+	v, err := toBytes(value, encoding)
+	if err != nil {
+		return -1, err
+	}
+	start, _ := b.bounds(offset)
+	if i := bytes.Index((*b)[start:], v); i >= 0 {
+		return start + i, nil
+	}
+	return -1, nil
+}
+
+func (b *Buffer) LastIndexOf(value interface{}, encoding string) (int, error) {
+	v, err := toBytes(value, encoding)
+	if err != nil {
+		return -1, err
+	}
+	return bytes.LastIndex(*b, v), nil
+}
+
+func (b *Buffer) Includes(value interface{}, offset int, encoding string) (bool, error) {
+	i, err := b.IndexOf(value, offset, encoding)
+	return i >= 0, err
+}
+
+//#endregion
+
+//#region 切片、拷贝、填充
+
+func (b *Buffer) Slice(params ...int) *Buffer { // 与 Node.js 一致，返回的切片与原 Buffer 共享同一块内存
+	start, end := b.bounds(params...)
+	s := (*b)[start:end]
+	return &s
+}
+
+func (b *Buffer) Subarray(params ...int) *Buffer {
+	return b.Slice(params...)
+}
+
+func (b *Buffer) Copy(target []byte, params ...int) int {
+	targetStart := 0
+	if len(params) > 0 {
+		targetStart = params[0]
+	}
+	if targetStart < 0 || targetStart >= len(target) {
+		return 0
+	}
+	start, end := b.bounds(params[min(1, len(params)):]...)
+	return copy(target[targetStart:], (*b)[start:end])
+}
+
+func (b *Buffer) Fill(value interface{}, params ...goja.Value) (*Buffer, error) {
+	// 解析可选参数：fill(value[, offset[, end]][, encoding])
+	offsets, encoding := make([]int, 0, 2), ""
+	for _, p := range params {
+		if p == nil || goja.IsUndefined(p) || goja.IsNull(p) {
+			continue
+		}
+		if s, ok := p.Export().(string); ok {
+			encoding = s
+			break
+		}
+		offsets = append(offsets, int(p.ToInteger()))
+	}
+
+	v, err := toBytes(value, encoding)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		v = []byte{0}
+	}
+
+	start, end := b.bounds(offsets...)
+	for i := start; i < end; i += len(v) {
+		copy((*b)[i:end], v)
+	}
+	return b, nil
+}
+
+func (b *Buffer) Write(input string, params ...goja.Value) (int, error) {
+	// 解析可选参数：write(string[, offset[, length]][, encoding])
+	offsets, encoding := make([]int, 0, 2), ""
+	for _, p := range params {
+		if p == nil || goja.IsUndefined(p) || goja.IsNull(p) {
+			continue
+		}
+		if s, ok := p.Export().(string); ok {
+			encoding = s
+			break
+		}
+		offsets = append(offsets, int(p.ToInteger()))
+	}
+
+	v, err := decode([]byte(input), encoding)
+	if err != nil {
+		return 0, err
+	}
+
+	offset, length := 0, len(*b)
+	if len(offsets) > 0 {
+		offset = offsets[0]
+	}
+	if offset < 0 || offset > len(*b) {
+		return 0, errors.New("offset out of range")
+	}
+	length -= offset
+	if len(offsets) > 1 && offsets[1] < length {
+		length = offsets[1]
+	}
+	return copy((*b)[offset:offset+length], v), nil
+}
+
+//#endregion
+
+//#region 读取数值
+
+func (b *Buffer) ReadUInt8(offset int) (uint8, error) {
+	if err := b.check(offset, 1); err != nil {
+		return 0, err
+	}
+	return (*b)[offset], nil
+}
+
+func (b *Buffer) ReadUInt16LE(offset int) (uint16, error) {
+	if err := b.check(offset, 2); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16((*b)[offset:]), nil
+}
+
+func (b *Buffer) ReadUInt16BE(offset int) (uint16, error) {
+	if err := b.check(offset, 2); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16((*b)[offset:]), nil
+}
+
+func (b *Buffer) ReadUInt32LE(offset int) (uint32, error) {
+	if err := b.check(offset, 4); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32((*b)[offset:]), nil
+}
+
+func (b *Buffer) ReadUInt32BE(offset int) (uint32, error) {
+	if err := b.check(offset, 4); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32((*b)[offset:]), nil
+}
+
+func (b *Buffer) ReadInt8(offset int) (int8, error) {
+	v, err := b.ReadUInt8(offset)
+	return int8(v), err
+}
+
+func (b *Buffer) ReadInt16LE(offset int) (int16, error) {
+	v, err := b.ReadUInt16LE(offset)
+	return int16(v), err
+}
+
+func (b *Buffer) ReadInt16BE(offset int) (int16, error) {
+	v, err := b.ReadUInt16BE(offset)
+	return int16(v), err
+}
+
+func (b *Buffer) ReadInt32LE(offset int) (int32, error) {
+	v, err := b.ReadUInt32LE(offset)
+	return int32(v), err
+}
+
+func (b *Buffer) ReadInt32BE(offset int) (int32, error) {
+	v, err := b.ReadUInt32BE(offset)
+	return int32(v), err
+}
+
+func (b *Buffer) ReadBigUInt64LE(offset int) (*big.Int, error) { // 返回值 *big.Int 对应 js 中的 bigint 类型
+	if err := b.check(offset, 8); err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetUint64(binary.LittleEndian.Uint64((*b)[offset:])), nil
+}
+
+func (b *Buffer) ReadBigUInt64BE(offset int) (*big.Int, error) {
+	if err := b.check(offset, 8); err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetUint64(binary.BigEndian.Uint64((*b)[offset:])), nil
+}
+
+func (b *Buffer) ReadBigInt64LE(offset int) (*big.Int, error) {
+	if err := b.check(offset, 8); err != nil {
+		return nil, err
+	}
+	return big.NewInt(int64(binary.LittleEndian.Uint64((*b)[offset:]))), nil
+}
+
+func (b *Buffer) ReadBigInt64BE(offset int) (*big.Int, error) {
+	if err := b.check(offset, 8); err != nil {
+		return nil, err
+	}
+	return big.NewInt(int64(binary.BigEndian.Uint64((*b)[offset:]))), nil
+}
+
+func (b *Buffer) ReadFloatLE(offset int) (float32, error) {
+	v, err := b.ReadUInt32LE(offset)
+	return math.Float32frombits(v), err
+}
+
+func (b *Buffer) ReadFloatBE(offset int) (float32, error) {
+	v, err := b.ReadUInt32BE(offset)
+	return math.Float32frombits(v), err
+}
+
+func (b *Buffer) ReadDoubleLE(offset int) (float64, error) {
+	if err := b.check(offset, 8); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64((*b)[offset:])), nil
+}
+
+func (b *Buffer) ReadDoubleBE(offset int) (float64, error) {
+	if err := b.check(offset, 8); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64((*b)[offset:])), nil
+}
+
+//#endregion
+
+//#region 写入数值，返回值为写入后的偏移量
+
+func (b *Buffer) WriteUInt8(value int64, offset int) (int, error) {
+	if err := b.check(offset, 1); err != nil {
+		return 0, err
+	}
+	(*b)[offset] = byte(value)
+	return offset + 1, nil
+}
+
+func (b *Buffer) WriteUInt16LE(value int64, offset int) (int, error) {
+	if err := b.check(offset, 2); err != nil {
+		return 0, err
+	}
+	binary.LittleEndian.PutUint16((*b)[offset:], uint16(value))
+	return offset + 2, nil
+}
+
+func (b *Buffer) WriteUInt16BE(value int64, offset int) (int, error) {
+	if err := b.check(offset, 2); err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint16((*b)[offset:], uint16(value))
+	return offset + 2, nil
+}
+
+func (b *Buffer) WriteUInt32LE(value int64, offset int) (int, error) {
+	if err := b.check(offset, 4); err != nil {
+		return 0, err
+	}
+	binary.LittleEndian.PutUint32((*b)[offset:], uint32(value))
+	return offset + 4, nil
+}
+
+func (b *Buffer) WriteUInt32BE(value int64, offset int) (int, error) {
+	if err := b.check(offset, 4); err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint32((*b)[offset:], uint32(value))
+	return offset + 4, nil
+}
+
+func (b *Buffer) WriteInt8(value int64, offset int) (int, error) {
+	return b.WriteUInt8(value, offset) // 有符号整数按补码写入，与无符号整数的写入方式一致
+}
+
+func (b *Buffer) WriteInt16LE(value int64, offset int) (int, error) {
+	return b.WriteUInt16LE(value, offset)
+}
+
+func (b *Buffer) WriteInt16BE(value int64, offset int) (int, error) {
+	return b.WriteUInt16BE(value, offset)
+}
+
+func (b *Buffer) WriteInt32LE(value int64, offset int) (int, error) {
+	return b.WriteUInt32LE(value, offset)
+}
+
+func (b *Buffer) WriteInt32BE(value int64, offset int) (int, error) {
+	return b.WriteUInt32BE(value, offset)
+}
+
+func (b *Buffer) WriteBigUInt64LE(value *big.Int, offset int) (int, error) {
+	if err := b.check(offset, 8); err != nil {
+		return 0, err
+	}
+	binary.LittleEndian.PutUint64((*b)[offset:], toUint64(value))
+	return offset + 8, nil
+}
+
+func (b *Buffer) WriteBigUInt64BE(value *big.Int, offset int) (int, error) {
+	if err := b.check(offset, 8); err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint64((*b)[offset:], toUint64(value))
+	return offset + 8, nil
+}
+
+func (b *Buffer) WriteBigInt64LE(value *big.Int, offset int) (int, error) {
+	return b.WriteBigUInt64LE(value, offset)
+}
+
+func (b *Buffer) WriteBigInt64BE(value *big.Int, offset int) (int, error) {
+	return b.WriteBigUInt64BE(value, offset)
+}
+
+func (b *Buffer) WriteFloatLE(value float64, offset int) (int, error) {
+	return b.WriteUInt32LE(int64(math.Float32bits(float32(value))), offset)
+}
+
+func (b *Buffer) WriteFloatBE(value float64, offset int) (int, error) {
+	return b.WriteUInt32BE(int64(math.Float32bits(float32(value))), offset)
+}
+
+func (b *Buffer) WriteDoubleLE(value float64, offset int) (int, error) {
+	if err := b.check(offset, 8); err != nil {
+		return 0, err
+	}
+	binary.LittleEndian.PutUint64((*b)[offset:], math.Float64bits(value))
+	return offset + 8, nil
+}
+
+func (b *Buffer) WriteDoubleBE(value float64, offset int) (int, error) {
+	if err := b.check(offset, 8); err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint64((*b)[offset:], math.Float64bits(value))
+	return offset + 8, nil
+}
+
+//#endregion
+
+// 校验从 offset 开始读写 size 个字节是否越界
+func (b *Buffer) check(offset int, size int) error {
+	if offset < 0 || offset+size > len(*b) {
+		return errors.New("offset out of range")
+	}
+	return nil
+}
+
+// 计算 [start, end) 区间，与 Node.js 一致，支持负数下标（从末尾开始计算）
+func (b *Buffer) bounds(params ...int) (int, int) {
+	length := len(*b)
+	start, end := 0, length
+	if len(params) > 0 {
+		start = params[0]
+	}
+	if len(params) > 1 {
+		end = params[1]
+	}
+	if start < 0 {
+		start = max(length+start, 0)
+	}
+	if end < 0 {
+		end = max(length+end, 0)
+	}
+	start, end = min(start, length), min(end, length)
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+func toUint64(v *big.Int) uint64 {
+	if v == nil {
+		return 0
+	}
+	if v.Sign() < 0 {
+		return uint64(v.Int64())
+	}
+	return v.Uint64()
+}
+
+// 将 js 中的 string、number、Array<number>、Uint8Array、ArrayBuffer 或 Buffer 转换为 []byte
+func toBytes(value interface{}, encoding string) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return decode([]byte(v), encoding)
+	case int64:
+		return []byte{byte(v)}, nil
+	case float64:
+		return []byte{byte(v)}, nil
+	case []byte:
+		return v, nil
+	case Buffer:
+		return v, nil
+	case *Buffer:
+		return *v, nil
+	case goja.ArrayBuffer:
+		return v.Bytes(), nil
+	case []interface{}:
+		b := make([]byte, len(v))
+		for i, e := range v {
+			switch n := e.(type) {
+			case int64:
+				b[i] = byte(n)
+			case float64:
+				b[i] = byte(n)
+			}
+		}
+		return b, nil
+	}
+	return nil, errors.New("unsupported value type")
+}
+
 func encode(input []byte, encoding string) (string, error) {
 	switch encoding {
-	case "", "utf8":
+	case "", "utf8", "utf-8":
 		return string(input), nil
 	case "hex":
 		return hex.EncodeToString(input), nil
@@ -47,13 +553,34 @@ func encode(input []byte, encoding string) (string, error) {
 		return base64.StdEncoding.EncodeToString(input), nil
 	case "base64url":
 		return base64.URLEncoding.EncodeToString(input), nil
+	case "latin1", "binary":
+		r := make([]rune, len(input))
+		for i, c := range input {
+			r[i] = rune(c)
+		}
+		return string(r), nil
+	case "ascii":
+		r := make([]rune, len(input))
+		for i, c := range input {
+			r[i] = rune(c & 0x7f)
+		}
+		return string(r), nil
+	case "utf16le", "utf-16le", "ucs2", "ucs-2":
+		u := make([]uint16, len(input)/2)
+		for i := range u {
+			u[i] = binary.LittleEndian.Uint16(input[i*2:])
+		}
+		return string(utf16.Decode(u)), nil
+	}
+	if e, err := htmlindex.Get(encoding); err == nil { // 其它字符集如 gbk、gb18030、big5、shift_jis、euc-kr 等，按 WHATWG 标准的名称查找
+		return e.NewDecoder().String(string(input))
 	}
 	return "", errors.New("unsupported encoding: " + encoding)
 }
 
 func decode(input []byte, encoding string) ([]byte, error) {
 	switch encoding {
-	case "", "utf8":
+	case "", "utf8", "utf-8":
 		return input, nil
 	case "hex":
 		return hex.DecodeString(string(input))
@@ -61,6 +588,24 @@ func decode(input []byte, encoding string) ([]byte, error) {
 		return base64.StdEncoding.DecodeString(string(input))
 	case "base64url":
 		return base64.URLEncoding.DecodeString(string(input))
+	case "latin1", "binary", "ascii": // 与 Node.js 一致，每个字符仅保留低 8 位
+		output := make([]byte, 0, len(input))
+		for len(input) > 0 {
+			r, size := utf8.DecodeRune(input)
+			output = append(output, byte(r))
+			input = input[size:]
+		}
+		return output, nil
+	case "utf16le", "utf-16le", "ucs2", "ucs-2":
+		u := utf16.Encode([]rune(string(input)))
+		output := make([]byte, len(u)*2)
+		for i, c := range u {
+			binary.LittleEndian.PutUint16(output[i*2:], c)
+		}
+		return output, nil
+	}
+	if e, err := htmlindex.Get(encoding); err == nil {
+		return e.NewEncoder().Bytes(input)
 	}
 	return nil, errors.New("unsupported encoding: " + encoding)
 }
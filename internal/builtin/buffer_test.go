@@ -0,0 +1,102 @@
+package builtin
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestBufferReadWrite(t *testing.T) {
+	b := make(Buffer, 24)
+
+	offset, _ := b.WriteUInt8(0xff, 0)
+	offset, _ = b.WriteUInt16BE(0x0102, offset)
+	offset, _ = b.WriteInt32LE(-2, offset)
+	offset, _ = b.WriteDoubleBE(1.5, offset)
+	offset, _ = b.WriteBigInt64LE(big.NewInt(-3), offset)
+	if offset != 23 {
+		t.Fatal("unexpected offset", offset)
+	}
+
+	if v, _ := b.ReadUInt8(0); v != 0xff {
+		t.Fatal("unexpected uint8", v)
+	}
+	if v, _ := b.ReadInt8(0); v != -1 {
+		t.Fatal("unexpected int8", v)
+	}
+	if v, _ := b.ReadUInt16BE(1); v != 0x0102 {
+		t.Fatal("unexpected uint16", v)
+	}
+	if v, _ := b.ReadUInt16LE(1); v != 0x0201 {
+		t.Fatal("unexpected uint16", v)
+	}
+	if v, _ := b.ReadInt32LE(3); v != -2 {
+		t.Fatal("unexpected int32", v)
+	}
+	if v, _ := b.ReadDoubleBE(7); v != 1.5 {
+		t.Fatal("unexpected double", v)
+	}
+	if v, _ := b.ReadBigInt64LE(15); v.Int64() != -3 {
+		t.Fatal("unexpected bigint64", v)
+	}
+
+	if _, err := b.ReadUInt32LE(21); err == nil {
+		t.Fatal("expected out of range error")
+	}
+}
+
+func TestBufferSlice(t *testing.T) {
+	b := Buffer("hello, world")
+
+	s := b.Slice(-5)
+	if v, _ := s.ToString(""); v != "world" {
+		t.Fatal("unexpected slice", v)
+	}
+
+	// 切片与原 Buffer 共享内存
+	(*s)[0] = 'W'
+	if v, _ := b.ToString("", 7, 8); v != "W" {
+		t.Fatal("slice should share memory with the original buffer", v)
+	}
+
+	if i, _ := b.IndexOf("o", 5, ""); i != 8 {
+		t.Fatal("unexpected index", i)
+	}
+
+	target := make([]byte, 5)
+	if n := b.Copy(target, 0, 7); n != 5 || string(target) != "World" {
+		t.Fatal("unexpected copy", n, string(target))
+	}
+}
+
+func TestBufferEncoding(t *testing.T) {
+	for _, c := range []struct {
+		encoding string
+		input    string
+		output   []byte
+	}{
+		{"latin1", "é", []byte{0xe9}},
+		{"ascii", "abc", []byte("abc")},
+		{"utf16le", "中", []byte{0x2d, 0x4e}},
+		{"hex", "0a0b", []byte{0x0a, 0x0b}},
+		{"gbk", "中文", []byte{0xd6, 0xd0, 0xce, 0xc4}},
+		{"gb18030", "€", []byte{0xa2, 0xe3}},
+		{"big5", "中文", []byte{0xa4, 0xa4, 0xa4, 0xe5}},
+		{"shift_jis", "日本", []byte{0x93, 0xfa, 0x96, 0x7b}},
+	} {
+		b, err := decode([]byte(c.input), c.encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, c.output) {
+			t.Fatal("unexpected decoding", c.encoding, b)
+		}
+		s, err := encode(b, c.encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != c.input {
+			t.Fatal("unexpected encoding", c.encoding, s)
+		}
+	}
+}
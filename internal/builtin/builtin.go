@@ -3,6 +3,8 @@ package builtin
 import (
 	"database/sql"
 
+	"cube/internal/log"
+
 	"github.com/dop251/goja"
 )
 
@@ -14,6 +16,8 @@ type Worker interface {
 	Runtime() *goja.Runtime
 	EventLoop() *EventLoop
 	Interrupt(reason string)
+	RequestId() string
+	LogFields() log.Fields
 }
 
 type Context struct {
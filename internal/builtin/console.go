@@ -13,21 +13,21 @@ type ConsoleClient struct {
 }
 
 func (c *ConsoleClient) Log(e ...interface{}) {
-	log.Log(c.worker.Id(), e...)
+	log.Log(c.worker.LogFields(), e...)
 }
 
 func (c *ConsoleClient) Debug(e ...interface{}) {
-	log.Debug(c.worker.Id(), e...)
+	log.Debug(c.worker.LogFields(), e...)
 }
 
 func (c *ConsoleClient) Info(e ...interface{}) {
-	log.Info(c.worker.Id(), e...)
+	log.Info(c.worker.LogFields(), e...)
 }
 
 func (c *ConsoleClient) Warn(e ...interface{}) {
-	log.Warn(c.worker.Id(), e...)
+	log.Warn(c.worker.LogFields(), e...)
 }
 
 func (c *ConsoleClient) Error(e ...interface{}) {
-	log.Error(c.worker.Id(), e...)
+	log.Error(c.worker.LogFields(), e...)
 }
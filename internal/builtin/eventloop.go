@@ -94,6 +94,22 @@ func (l *EventLoop) Reset() {
 	}
 }
 
+// Post 从其他协程向宏任务队列投递任务，直到投递成功或 done 被关闭，返回是否投递成功
+// 与 EventTaskTrigger.AddTask 不同，该方法不增加计数器，任务仅在事件循环仍在运行时被执行，调用方需要确保 done 在 Reset 之前被关闭
+func (l *EventLoop) Post(fn func(), done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return false
+	default:
+	}
+	select {
+	case <-done:
+		return false
+	case l.tasks <- fn:
+		return true
+	}
+}
+
 func (l *EventLoop) NewEventTaskTrigger() *EventTaskTrigger {
 	l.count++
 	return &EventTaskTrigger{
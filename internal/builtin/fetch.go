@@ -25,6 +25,9 @@ func init() {
 			for k, v := range options.Headers {
 				req.Header.Set(k, v)
 			}
+			if id := ctx.Worker.RequestId(); id != "" && req.Header.Get("X-Request-Id") == "" { // 透传当前请求 ID
+				req.Header.Set("X-Request-Id", id)
+			}
 
 			runtime := ctx.Worker.Runtime()
 			promise, resolve, reject := runtime.NewPromise()
@@ -93,6 +96,6 @@ func (f *FetchResponse) Json() (v map[string]interface{}, err error) {
 	return
 }
 
-func (f *FetchResponse) Text() string {
-	return string(f.data)
+func (f *FetchResponse) Text(charset string) (string, error) { // charset 为空时按 utf8 解码，也可指定 gbk、gb18030、big5 等字符集
+	return (*Buffer)(&f.data).ToString(charset)
 }
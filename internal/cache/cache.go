@@ -6,7 +6,6 @@ import (
 
 	"cube/internal/model"
 
-	"github.com/dop251/goja"
 	"github.com/robfig/cron/v3"
 )
 
@@ -16,6 +15,7 @@ var (
 	Crontab    *CrontabCache
 	Daemon     *DaemonCache
 	Module     *ModuleCache
+	Preview    *ModuleCache // 预览草稿时使用的模块缓存
 	DB         *DBCache
 )
 
@@ -42,8 +42,13 @@ func Init(db *sql.DB) error {
 		daemons: make(map[string]Worker),
 	}
 
+	Preview = &ModuleCache{
+		modules: make(map[string]*CompiledModule),
+	}
+
 	Module = &ModuleCache{
-		modules: make(map[string]*goja.Program),
+		modules: make(map[string]*CompiledModule),
+		preview: Preview,
 	}
 
 	DB = &DBCache{
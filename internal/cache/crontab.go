@@ -1,20 +1,42 @@
 package cache
 
-import "github.com/robfig/cron/v3"
+import (
+	"sync"
+
+	"github.com/robfig/cron/v3"
+)
 
 type CrontabCache struct {
+	sync.RWMutex
 	crontabs map[string]cron.EntryID
 }
 
 func (c *CrontabCache) Add(name string, id cron.EntryID) {
+	c.Lock()
+	defer c.Unlock()
 	c.crontabs[name] = id
 }
 
 func (c *CrontabCache) Get(name string) (cron.EntryID, bool) {
+	c.RLock()
+	defer c.RUnlock()
 	id, exists := c.crontabs[name]
 	return id, exists
 }
 
 func (c *CrontabCache) Remove(name string) {
+	c.Lock()
+	defer c.Unlock()
 	delete(c.crontabs, name)
 }
+
+// Names 返回定时任务 id 与名称的映射
+func (c *CrontabCache) Names() map[cron.EntryID]string {
+	c.RLock()
+	defer c.RUnlock()
+	names := make(map[cron.EntryID]string, len(c.crontabs))
+	for name, id := range c.crontabs {
+		names[id] = name
+	}
+	return names
+}
@@ -1,6 +1,11 @@
 package cache
 
-import "github.com/dop251/goja"
+import (
+	"sort"
+	"sync"
+
+	"github.com/dop251/goja"
+)
 
 // Worker 需要从 internal 包导入，但由于循环依赖问题，这里使用 interface
 // 实际使用时，需要确保传入的是正确的 Worker 类型
@@ -13,18 +18,37 @@ type Worker interface {
 }
 
 type DaemonCache struct {
-	daemons map[string]Worker
+	sync.RWMutex // daemon 在各自的协程中启停，同时可能被监控指标并发读取
+	daemons      map[string]Worker
 }
 
 func (c *DaemonCache) Add(name string, worker Worker) {
+	c.Lock()
+	defer c.Unlock()
 	c.daemons[name] = worker
 }
 
 func (c *DaemonCache) Get(name string) (Worker, bool) {
+	c.RLock()
+	defer c.RUnlock()
 	worker, exists := c.daemons[name]
 	return worker, exists
 }
 
 func (c *DaemonCache) Remove(name string) {
+	c.Lock()
+	defer c.Unlock()
 	delete(c.daemons, name)
 }
+
+// Names 返回正在运行的 daemon 名称，按名称排序
+func (c *DaemonCache) Names() []string {
+	c.RLock()
+	defer c.RUnlock()
+	names := make([]string, 0, len(c.daemons))
+	for name := range c.daemons {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -1,24 +1,72 @@
 package cache
 
-import "github.com/dop251/goja"
+import (
+	"sync"
+
+	"cube/internal/metrics"
+
+	"github.com/dop251/goja"
+)
+
+// CompiledModule 编译后的模块
+type CompiledModule struct {
+	Id      string // 解析后的模块 id，如 require("./lib") 解析为 "./lib/index"
+	Program *goja.Program
+}
 
 type ModuleCache struct {
-	modules map[string]*goja.Program
+	modules map[string]*CompiledModule // 键为 require 的模块 id，同一模块可能以多个 id 缓存，如 "./lib" 和 "./lib/index"
+	version uint64                     // 每次删除或清空缓存时递增，用于判断 worker 中常驻的模块实例是否已过期
+	mu      sync.RWMutex
+	preview *ModuleCache // 预览时使用的缓存，其中的模块优先使用草稿编译，已发布的源码变更后同样过期
 }
 
-func (c *ModuleCache) Add(name string, program *goja.Program) {
-	c.modules[name] = program
+func (c *ModuleCache) Add(name string, module *CompiledModule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.modules[name] = module
 }
 
-func (c *ModuleCache) Get(name string) (*goja.Program, bool) {
-	program, exists := c.modules[name]
-	return program, exists
+func (c *ModuleCache) Get(name string) (*CompiledModule, bool) {
+	c.mu.RLock()
+	module, exists := c.modules[name]
+	c.mu.RUnlock()
+	if exists {
+		metrics.ModuleCacheHits.Inc()
+	} else {
+		metrics.ModuleCacheMisses.Inc()
+	}
+	return module, exists
 }
 
+// Remove 删除模块的缓存，包括解析到该模块的其他 id 的缓存
 func (c *ModuleCache) Remove(name string) {
-	delete(c.modules, name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.modules {
+		if k == name || v.Id == name {
+			delete(c.modules, k)
+		}
+	}
+	c.version++
+	if c.preview != nil {
+		c.preview.Remove(name)
+	}
 }
 
 func (c *ModuleCache) Clear() {
-	c.modules = make(map[string]*goja.Program)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.modules = make(map[string]*CompiledModule)
+	c.version++
+	if c.preview != nil {
+		c.preview.Clear()
+	}
+}
+
+// Version 返回缓存的版本号，任一模块的源码变更后版本号都会改变
+func (c *ModuleCache) Version() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
 }
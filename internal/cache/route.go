@@ -32,26 +32,42 @@ func (c *RouteCache) Init() error {
 
 func (c *RouteCache) Get(path string) (string, map[string]string) {
 	for k, v := range c.routes {
-		values := v.FindAllStringSubmatch(path, -1)
-		if len(values) == 0 {
-			continue
-		}
-
-		groups := v.SubexpNames()
-		m := make(map[string]string)
-		for i, name := range groups {
-			if i == 0 {
-				continue
-			}
-			m[name] = values[0][i]
+		if m, ok := matchRoute(v, path); ok {
+			return k, m
 		}
-		return k, m
 	}
 	return "", nil
 }
 
 func (c *RouteCache) Set(name, path string) {
-	c.routes[name] = regexp.MustCompile("^" + regexp.MustCompile("{(.*?)}").ReplaceAllString(path, "(?P<$1>.*?)") + "$")
+	c.routes[name] = routePattern(path)
+}
+
+// MatchRoute 判断路径是否匹配 controller 的 url，并返回 url 中的变量，用于匹配不在缓存中的（未启用的）controller
+func MatchRoute(url string, path string) (map[string]string, bool) {
+	return matchRoute(routePattern(url), path)
+}
+
+// 将 url 中的变量（如 {id}）转换为命名分组
+func routePattern(url string) *regexp.Regexp {
+	return regexp.MustCompile("^" + regexp.MustCompile("{(.*?)}").ReplaceAllString(url, "(?P<$1>.*?)") + "$")
+}
+
+func matchRoute(pattern *regexp.Regexp, path string) (map[string]string, bool) {
+	values := pattern.FindAllStringSubmatch(path, -1)
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	groups := pattern.SubexpNames()
+	m := make(map[string]string)
+	for i, name := range groups {
+		if i == 0 {
+			continue
+		}
+		m[name] = values[0][i]
+	}
+	return m, true
 }
 
 func (c *RouteCache) Remove(name string) {
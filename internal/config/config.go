@@ -11,10 +11,21 @@ var (
 	ServerCert       string
 	ClientCertVerify bool
 	IdeAuthorization string
+	LogLevel         string
+	LogMaxSize       int
+	LogMaxBackups    int
+	AccessLog        string
+	LogBuffer        int
+	LogBufferPersist bool
+	RemoteOffline    bool
+	HistoryVersions  int
+	HistoryDays      int
+	Watch            string
+	SessionHours     int
 )
 
 func init() {
-	// 获取启动参数
+	// 定义启动参数，在 main 中解析，以便测试时不解析 go test 的参数
 	flag.IntVar(&Count, "n", 16, "count of virtual machines") // 定义命令行参数 c，表示虚拟机的个数，返回 Int 类型指针，默认值为 16，其值在 Parse 后会被修改为命令参数指定的值
 	flag.StringVar(&Port, "p", "8090", "port to listen")
 	flag.BoolVar(&Secure, "s", false, "enable https")
@@ -22,8 +33,16 @@ func init() {
 	flag.StringVar(&ServerKey, "k", "server.key", "SSL key")
 	flag.StringVar(&ServerCert, "c", "server.crt", "SSL cert")
 	flag.BoolVar(&ClientCertVerify, "v", false, "enable client cert verification")
-	flag.StringVar(&IdeAuthorization, "a", "", "<username:password> for ide authorization verification")
-
-	// 在定义命令行参数之后，调用 Parse 方法对所有命令行参数进行解析
-	flag.Parse()
+	flag.StringVar(&IdeAuthorization, "a", "", "<username:password> of an admin account, created or reset at startup; the ide requires login once any account exists")
+	flag.IntVar(&SessionHours, "session-hours", 168, "hours after which an ide login session expires")
+	flag.StringVar(&LogLevel, "log-level", "debug", "minimum log level: debug, log, info, warn or error")
+	flag.IntVar(&LogMaxSize, "log-max-size", 64, "maximum size in megabytes of the log file before it gets rotated")
+	flag.IntVar(&LogMaxBackups, "log-max-backups", 7, "maximum number of rotated log files to retain")
+	flag.StringVar(&AccessLog, "access-log", "json", "format of the access log: json, combined or off")
+	flag.IntVar(&LogBuffer, "log-buffer", 1000, "number of recent log entries kept in memory for each daemon and crontab, 0 to disable")
+	flag.BoolVar(&LogBufferPersist, "log-buffer-persist", false, "persist the log entries of each daemon and crontab under ./logs")
+	flag.IntVar(&HistoryVersions, "history-versions", 50, "number of versions kept in the history of each source, 0 for unlimited")
+	flag.IntVar(&HistoryDays, "history-days", 0, "number of days the versions of sources are kept, 0 for unlimited")
+	flag.BoolVar(&RemoteOffline, "remote-offline", false, "never download remote modules on demand, only load the ones already stored in the database")
+	flag.StringVar(&Watch, "watch", "", "directory exported by cube export, whose sources are imported whenever the files change")
 }
@@ -76,19 +76,42 @@ func (s *ServiceContext) GetBody() (builtin.Buffer, error) { // 返回类型如
 	return io.ReadAll(s.request.Body)
 }
 
+func (s *ServiceContext) GetRequestId() string {
+	return s.request.Header.Get("X-Request-Id")
+}
+
 func (s *ServiceContext) GetMethod() string {
 	return s.request.Method
 }
 
-func (s *ServiceContext) GetForm() interface{} {
+func (s *ServiceContext) GetForm(charset string) (interface{}, error) {
 	s.request.ParseForm() // 需要转换后才能获取表单
 
+	// 表单中的参数经过 URL 解码后为原始字节，如果客户端使用了 gbk 等非 utf8 字符集编码，则需要按指定的字符集进行转换
+	convert := func(v string) (string, error) {
+		b := builtin.Buffer(v)
+		return b.ToString(charset)
+	}
+
 	params := make(map[string][]string)
 	for name, values := range s.request.Form {
-		params[name] = values
+		if charset == "" {
+			params[name] = values
+			continue
+		}
+		key, err := convert(name)
+		if err != nil {
+			return nil, err
+		}
+		params[key] = make([]string, len(values))
+		for i, value := range values {
+			if params[key][i], err = convert(value); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	return params
+	return params, nil
 }
 
 func (s *ServiceContext) GetPathVariables() interface{} {
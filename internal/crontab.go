@@ -1,16 +1,39 @@
 package internal
 
 import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+	"unicode/utf8"
+
 	"cube/internal/cache"
+	"cube/internal/log"
+	"cube/internal/metrics"
+	"cube/internal/util"
+
 	"github.com/robfig/cron/v3"
 )
 
-var Crontab *cron.Cron // 定时任务
+const (
+	crontabRunResultLimit  = 1024        // 执行结果的最大保存长度
+	crontabRunHistoryKept  = 100         // 每个定时任务保留的执行记录条数
+	crontabRetryBackoff    = time.Second // 首次重试的等待时长，之后每次翻倍
+	crontabRetryBackoffMax = time.Minute // 重试的最大等待时长
+)
+
+var (
+	Crontab      *cron.Cron // 定时任务
+	crontabLocks sync.Map   // 定时任务的执行锁，用于 skip、queue 策略，键为定时任务名称
+)
 
 func RunCrontabs(name string) {
 	if Crontab == nil { // 首次执行时，先初始化 Crontab
-		Crontab = cron.New()
+		Crontab = cron.New(cron.WithParser(util.CronParser))
 		Crontab.Start()
+
+		// 服务重启前未执行完成的任务，标记为失败
+		Db.Exec("update crontab_run set status = 'error', error = 'server restarted' where status = 'running'")
 	}
 
 	if name == "" {
@@ -33,16 +56,127 @@ func RunCrontabs(name string) {
 		}
 
 		id, err := Crontab.AddFunc(c, func() {
-			worker := <-WorkerPool.Channels
-			defer func() {
-				WorkerPool.Channels <- worker
-			}()
-
-			worker.Run(worker.Runtime().ToValue("./crontab/" + n))
+			RunCrontab(n, false)
 		})
-		if err != nil {
-			panic(err)
+		if err != nil { // 跳过无效的 cron 表达式，以免影响其他定时任务和服务的启动
+			log.Error(log.Fields{Worker: -1, Source: n, Type: "crontab"}, "invalid cron expression:", err)
+			continue
 		}
 		cache.Crontab.Add(n, id)
 	}
 }
+
+// RunCrontab 执行一次定时任务，并记录执行历史
+func RunCrontab(name string, manual bool) {
+	fields := log.Fields{Worker: -1, Source: name, Type: "crontab"}
+
+	// 查询执行配置，每次执行时查询，以便配置修改后无需重新调度即可生效
+	var (
+		timeout, retries int
+		overlap          string
+	)
+	if err := Db.QueryRow("select timeout, overlap, retries from source where name = ? and type = 'crontab'", name).Scan(&timeout, &overlap, &retries); err != nil {
+		log.Error(fields, err)
+		return
+	}
+
+	// 上一次执行未完成时，跳过或排队等待
+	if overlap == "skip" || overlap == "queue" {
+		l, _ := crontabLocks.LoadOrStore(name, &sync.Mutex{})
+		lock := l.(*sync.Mutex)
+		if overlap == "queue" {
+			lock.Lock()
+		} else if !lock.TryLock() {
+			metrics.CrontabRuns.Inc(name, "skipped")
+			Db.Exec("insert into crontab_run (name, manual, status, start_time, end_time, error, attempts) values (?, ?, 'skipped', datetime('now', 'localtime'), datetime('now', 'localtime'), 'previous run is still in progress', 0)", name, manual)
+			return
+		}
+		defer lock.Unlock()
+	}
+
+	// 记录开始执行
+	res, err := Db.Exec("insert into crontab_run (name, manual, status, start_time) values (?, ?, 'running', datetime('now', 'localtime'))", name, manual)
+	if err != nil {
+		log.Error(fields, err)
+		return
+	}
+	id, _ := res.LastInsertId()
+
+	// 执行，失败后按指数退避重试
+	var (
+		result   string
+		start    = time.Now()
+		attempts = 0
+		backoff  = crontabRetryBackoff
+	)
+	for {
+		attempts++
+		if result, err = runCrontabOnce(name, timeout); err == nil || attempts > retries {
+			break
+		}
+		Db.Exec("update crontab_run set attempts = ?, error = ? where id = ?", attempts, err.Error(), id)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, crontabRetryBackoffMax)
+	}
+
+	// 记录执行结果
+	status, message := "success", ""
+	if err != nil {
+		status, message = "error", err.Error()
+	}
+	duration := time.Since(start)
+	metrics.CrontabDuration.Observe(duration.Seconds(), name)
+	metrics.CrontabRuns.Inc(name, status)
+
+	Db.Exec("update crontab_run set status = ?, end_time = datetime('now', 'localtime'), duration = ?, error = ?, result = ?, attempts = ? where id = ?", status, duration.Milliseconds(), message, result, attempts, id)
+	Db.Exec("delete from crontab_run where name = ? and id <= (select id from crontab_run where name = ? order by id desc limit 1 offset ?)", name, name, crontabRunHistoryKept)
+}
+
+// 获取 worker 执行一次定时任务，返回 JSON 序列化后的执行结果
+func runCrontabOnce(name string, timeout int) (result string, err error) {
+	worker := AcquireWorker()
+	defer func() {
+		if x := recover(); x != nil { // 从原生方法的 panic 中恢复，防止定时任务导致进程退出
+			err = fmt.Errorf("%v", x)
+		}
+		if err != nil {
+			log.Error(worker.LogFields(), err)
+		}
+
+		// 重置并归还实例，防止残留的中断信号或句柄影响下一次执行
+		worker.Reset()
+		WorkerPool.Channels <- worker
+	}()
+
+	// 超时后中断执行
+	if timeout > 0 {
+		timer := time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+			worker.Interrupt("crontab executed timeout")
+		})
+		defer timer.Stop()
+	}
+
+	v, err := worker.Run(worker.Runtime().ToValue("./crontab/" + name))
+	if err != nil {
+		return "", err
+	}
+	value, err := util.ExportGojaValue(v)
+	if err != nil || value == nil {
+		return "", err
+	}
+	if b, err := json.Marshal(value); err == nil {
+		result = truncate(string(b), crontabRunResultLimit)
+	}
+	return result, nil
+}
+
+// 按字节截断字符串，不截断多字节字符
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
@@ -1,8 +1,37 @@
 package internal
 
 import (
+	"fmt"
+	"sync"
+	"time"
+
 	"cube/internal/cache"
 	"cube/internal/log"
+	"cube/internal/metrics"
+	m "cube/internal/module"
+	"cube/internal/util"
+)
+
+const (
+	daemonRestartBackoff    = time.Second      // 首次重启的等待时长，之后每次翻倍
+	daemonRestartBackoffMax = time.Minute      // 重启的最大等待时长
+	daemonHealthyDuration   = 5 * time.Minute  // 运行超过该时长后退出，视为健康运行，重置等待时长和崩溃计数
+	daemonCrashLoopLimit    = 5                // 连续崩溃的次数达到该值后，视为崩溃循环，停止自动重启
+	daemonStopGracePeriod   = 10 * time.Second // 通知停止后，等待 daemon 自行退出的时长，超时后强制中断
+)
+
+// DaemonState daemon 的监管状态
+type DaemonState struct {
+	Status       string    // true（运行中）、stopping（正在停止）、restarting（等待重启）、crashloop（崩溃循环，已停止重启）、false（已停止）
+	Restarts     int       // 自动重启的次数
+	LastExit     string    // 最近一次退出的原因
+	LastExitTime time.Time // 最近一次退出的时间
+	stop         chan struct{}
+}
+
+var (
+	daemonStates  = make(map[string]*DaemonState) // 键为 daemon 名称
+	daemonStateMu sync.Mutex
 )
 
 func RunDaemons(name string) {
@@ -24,24 +53,175 @@ func RunDaemons(name string) {
 			continue
 		}
 
-		if _, exists := cache.Daemon.Get(n); exists { // 防止重复执行
+		// 防止重复执行，正在停止或等待重启的 daemon 同样视为运行中
+		daemonStateMu.Lock()
+		if s, ok := daemonStates[n]; ok && (s.Status == "true" || s.Status == "stopping" || s.Status == "restarting") {
+			daemonStateMu.Unlock()
 			continue
 		}
+		state := &DaemonState{Status: "true", stop: make(chan struct{})}
+		daemonStates[n] = state
+		daemonStateMu.Unlock()
+
+		go superviseDaemon(n, state)
+	}
+}
+
+// StopDaemon 停止 daemon，并取消等待中的重启
+// 运行中的 daemon 将收到停止通知（见 module.DaemonSignal），如果在宽限期内未退出，或者在正在停止时再次被停止，则强制中断
+func StopDaemon(name string) {
+	daemonStateMu.Lock()
+	s, ok := daemonStates[name]
+	if !ok {
+		daemonStateMu.Unlock()
+		return
+	}
+	status := s.Status
+	switch status {
+	case "true":
+		s.Status = "stopping"
+		close(s.stop)
+	case "restarting":
+		s.Status = "false"
+		close(s.stop)
+	}
+	daemonStateMu.Unlock()
+
+	signal, exists := m.GetDaemonSignal(name)
+	switch {
+	case status == "true" && exists:
+		signal.Stop()
+		time.AfterFunc(daemonStopGracePeriod, func() {
+			interruptDaemon(name, signal, "Daemon did not stop within "+daemonStopGracePeriod.String())
+		})
+	case status == "stopping" && exists:
+		interruptDaemon(name, signal, "Daemon stopped")
+	}
+}
+
+// 如果 daemon 的本次运行尚未退出，则强制中断
+func interruptDaemon(name string, signal *m.DaemonSignal, reason string) {
+	if s, ok := m.GetDaemonSignal(name); !ok || s != signal {
+		return
+	}
+	if worker, exists := cache.Daemon.Get(name); exists {
+		worker.Interrupt(reason) // 停止后会自动清理缓存，见 runDaemon 方法的 defer 实现
+	}
+}
+
+// GetDaemonState 获取 daemon 的监管状态的副本，未启动过的 daemon 返回已停止状态
+func GetDaemonState(name string) DaemonState {
+	daemonStateMu.Lock()
+	defer daemonStateMu.Unlock()
+	if s, ok := daemonStates[name]; ok {
+		return *s
+	}
+	return DaemonState{Status: "false"}
+}
+
+// 按重启策略监管 daemon 的运行
+func superviseDaemon(name string, state *DaemonState) {
+	var (
+		backoff = daemonRestartBackoff
+		crashes = 0
+	)
+	for {
+		start := time.Now()
+		err := runDaemon(name)
+
+		// 记录退出原因
+		reason := "exited"
+		if err != nil {
+			reason = err.Error()
+		}
+		daemonStateMu.Lock()
+		state.LastExit, state.LastExitTime = reason, time.Now()
+		stopped := state.Status == "false" || state.Status == "stopping"
+		if stopped { // 被手动停止
+			state.Status = "false"
+		}
+		daemonStateMu.Unlock()
+		if stopped {
+			return
+		}
+
+		// 查询重启策略，每次退出时查询，以便配置修改后即可生效
+		var restart string
+		if e := Db.QueryRow("select restart from source where name = ? and type = 'daemon' and active = true", name).Scan(&restart); e != nil {
+			restart = "never" // daemon 已被删除或禁用
+		}
+		if restart != "always" && (restart != "on-failure" || err == nil) {
+			setDaemonStatus(state, "false")
+			return
+		}
+
+		// 健康运行一段时间后退出，重置等待时长和崩溃计数
+		if time.Since(start) >= daemonHealthyDuration {
+			backoff, crashes = daemonRestartBackoff, 0
+		}
+		if crashes++; crashes >= daemonCrashLoopLimit {
+			log.Error(log.Fields{Worker: -1, Source: name, Type: "daemon"}, fmt.Sprintf("daemon exited %d times in a row, restart is stopped", crashes))
+			setDaemonStatus(state, "crashloop")
+			return
+		}
+
+		// 等待后重启
+		setDaemonStatus(state, "restarting")
+		select {
+		case <-state.stop:
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, daemonRestartBackoffMax)
 
-		go func() {
-			worker := <-WorkerPool.Channels
-			defer func() {
-				worker.Reset()
-				WorkerPool.Channels <- worker
-				cache.Daemon.Remove(n)
-			}()
+		daemonStateMu.Lock()
+		if state.Status == "false" { // 等待结束的同时被手动停止
+			daemonStateMu.Unlock()
+			return
+		}
+		state.Status = "true"
+		state.Restarts++
+		daemonStateMu.Unlock()
+		metrics.DaemonRestarts.Inc(name)
+	}
+}
+
+func setDaemonStatus(state *DaemonState, status string) {
+	daemonStateMu.Lock()
+	if state.Status != "false" {
+		state.Status = status
+	}
+	daemonStateMu.Unlock()
+}
 
-			cache.Daemon.Add(n, worker)
+// 获取 worker 运行一次 daemon，直到其退出
+func runDaemon(name string) (err error) {
+	worker := AcquireWorker()
+	defer func() {
+		if x := recover(); x != nil { // 从原生方法的 panic 中恢复，防止 daemon 导致进程退出
+			err = fmt.Errorf("%v", x)
+		}
+		if err != nil {
+			log.Error(worker.LogFields(), err)
+		}
+		worker.Reset()
+		WorkerPool.Channels <- worker
+		cache.Daemon.Remove(name)
+	}()
+
+	cache.Daemon.Add(name, worker)
+	signal := m.NewDaemonSignal(name, worker) // 需要在检查状态之前登记，以免错过停止通知
+	defer signal.Close()                      // 先于 worker 的重置执行
 
-			_, err := worker.Run(worker.Runtime().ToValue("./daemon/" + n))
-			if err != nil {
-				log.Error(worker.Id(), err)
-			}
-		}()
+	if status := GetDaemonState(name).Status; status == "false" || status == "stopping" { // 在等待 worker 的过程中被手动停止
+		return nil
+	}
+	metrics.DaemonStarts.Inc(name)
+
+	v, err := worker.Run(worker.Runtime().ToValue("./daemon/"+name), signal.Value())
+	if err != nil {
+		return err
 	}
+	_, err = util.ExportGojaValue(v) // 异步 daemon 返回的 Promise 被 reject 时，同样视为失败
+	return err
 }
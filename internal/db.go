@@ -8,10 +8,16 @@ import (
 
 var Db *sql.DB
 
-func InitDb() {
+// InitDb 打开数据库并创建表，file 为空时使用内存数据库，如在没有 cube.db 的目录中执行脚本
+func InitDb(file string) {
 	var err error
 
-	Db, err = sql.Open("sqlite", "./cube.db")
+	if file == "" {
+		Db, err = sql.Open("sqlite", "file::memory:")
+		Db.SetMaxOpenConns(1) // 每个连接中的内存数据库各自独立，只使用一个连接
+	} else {
+		Db, err = sql.Open("sqlite", file+"?_pragma=busy_timeout(5000)") // 并发写入时等待锁释放，而不是直接返回 SQLITE_BUSY
+	}
 	if err != nil {
 		panic(err)
 	}
@@ -23,16 +29,227 @@ func InitDb() {
 			lang varchar(16) not null,
 			content text not null default '',
 			compiled text not null default '',
+			source_map text not null default '', -- 编译后代码的源映射，用于将异常堆栈中的位置还原为源码中的位置
 			active boolean not null default false,
 			method varchar(8) not null default '',
 			url varchar(64) not null default '',
-			cron varchar(16) not null default '',
+			cron varchar(128) not null default '', -- 可包含秒字段和 CRON_TZ 时区前缀
 			tag text not null default '',
 			last_modified_date datetime default (datetime('now', 'localtime')),
+			timeout integer not null default 0,
+			overlap varchar(8) not null default 'allow',
+			retries integer not null default 0,
+			restart varchar(16) not null default 'never',
+			draft boolean not null default false, -- 是否存在未发布的草稿，草稿仅包含源码内容，发布后覆盖 content、compiled 和 source_map
+			draft_content text not null default '',
+			draft_compiled text not null default '',
+			draft_source_map text not null default '',
+			draft_modified_date datetime,
+			draft_base_date datetime, -- 保存草稿时已发布的版本号，发布时校验，以免覆盖在此期间修改或回滚的源码
 			primary key(name, type)
 		);
+		create table if not exists crontab_run (
+			id integer primary key autoincrement,
+			name varchar(64) not null,
+			manual boolean not null default false,
+			status varchar(16) not null,
+			start_time datetime not null,
+			end_time datetime,
+			duration integer not null default 0,
+			error text not null default '',
+			result text not null default '',
+			attempts integer not null default 1
+		);
+		create index if not exists crontab_run_name on crontab_run (name, id);
+		create table if not exists source_history ( -- 源码的历史版本，由触发器在新增、修改和删除源码时写入
+			id integer primary key autoincrement,
+			action varchar(8) not null, -- insert、update、delete
+			name varchar(64) not null,
+			type varchar(16) not null,
+			lang varchar(16) not null,
+			content text not null default '',
+			compiled text not null default '',
+			source_map text not null default '',
+			active boolean not null default false,
+			method varchar(8) not null default '',
+			url varchar(64) not null default '',
+			cron varchar(128) not null default '',
+			tag text not null default '',
+			timeout integer not null default 0,
+			overlap varchar(8) not null default 'allow',
+			retries integer not null default 0,
+			restart varchar(16) not null default 'never',
+			last_modified_date datetime, -- 该版本的最后修改时间
+			created_date datetime default (datetime('now', 'localtime'))
+		);
+		create index if not exists source_history_name on source_history (name, type, id);
+		create trigger if not exists source_history_insert after insert on source begin
+			insert into source_history (action, name, type, lang, content, compiled, source_map, active, method, url, cron, tag, timeout, overlap, retries, restart, last_modified_date)
+			values ('insert', new.name, new.type, new.lang, new.content, new.compiled, new.source_map, new.active, new.method, new.url, new.cron, new.tag, new.timeout, new.overlap, new.retries, new.restart, new.last_modified_date);
+		end;
+		create trigger if not exists source_history_update after update on source
+		when old.content is not new.content or old.compiled is not new.compiled or old.lang is not new.lang or old.method is not new.method or old.url is not new.url or old.cron is not new.cron or old.tag is not new.tag or old.timeout is not new.timeout or old.overlap is not new.overlap or old.retries is not new.retries or old.restart is not new.restart -- 仅启停时不记录
+		begin
+			insert into source_history (action, name, type, lang, content, compiled, source_map, active, method, url, cron, tag, timeout, overlap, retries, restart, last_modified_date)
+			values ('update', new.name, new.type, new.lang, new.content, new.compiled, new.source_map, new.active, new.method, new.url, new.cron, new.tag, new.timeout, new.overlap, new.retries, new.restart, new.last_modified_date);
+		end;
+		create trigger if not exists source_history_delete after delete on source begin
+			insert into source_history (action, name, type, lang, content, compiled, source_map, active, method, url, cron, tag, timeout, overlap, retries, restart, last_modified_date)
+			values ('delete', old.name, old.type, old.lang, old.content, old.compiled, old.source_map, old.active, old.method, old.url, old.cron, old.tag, old.timeout, old.overlap, old.retries, old.restart, old.last_modified_date);
+		end;
+		create table if not exists source_dependency ( -- 源码中静态 require 的模块，在已发布的源码变更时解析
+			name varchar(64) not null,
+			type varchar(16) not null,
+			module text not null, -- 解析后的模块 id，如 "./lib/date"、"lodash"
+			primary key(name, type, module)
+		);
+		create index if not exists source_dependency_module on source_dependency (module);
+		create trigger if not exists source_dependency_delete after delete on source begin
+			delete from source_dependency where name = old.name and type = old.type;
+		end;
+		create virtual table if not exists source_fts using fts5 ( -- 源码内容的全文索引，由触发器在新增、修改和删除源码时同步，trigram 分词支持任意子串的 like 查询
+			name unindexed,
+			type unindexed,
+			content,
+			tokenize = 'trigram'
+		);
+		create trigger if not exists source_fts_insert after insert on source begin
+			delete from source_fts where name = new.name and type = new.type; -- insert or replace 替换已有记录时不触发删除的触发器
+			insert into source_fts (name, type, content) values (new.name, new.type, new.content);
+		end;
+		create trigger if not exists source_fts_update after update of name, type, content on source
+		when old.content is not new.content or old.name is not new.name or old.type is not new.type
+		begin
+			delete from source_fts where name = old.name and type = old.type;
+			insert into source_fts (name, type, content) values (new.name, new.type, new.content);
+		end;
+		create trigger if not exists source_fts_delete after delete on source begin
+			delete from source_fts where name = old.name and type = old.type;
+		end;
+		create table if not exists remote_module (
+			url text not null primary key,
+			content text not null default '',
+			sha256 varchar(64) not null, -- 内容的摘要，加载时校验，防止被篡改
+			pinned boolean not null default false, -- 锁定后，刷新时内容不允许变更，除非指定新的摘要
+			fetched_date datetime default (datetime('now', 'localtime'))
+		);
+		create table if not exists user ( -- IDE 的账号，存在任一账号时 IDE 须登录后使用
+			name varchar(64) not null primary key,
+			password text not null, -- 口令的 PBKDF2 摘要，格式为 pbkdf2-sha256$<迭代次数>$<盐>$<摘要>
+			role varchar(16) not null, -- viewer、developer、publisher、admin
+			active boolean not null default true,
+			created_date datetime default (datetime('now', 'localtime')),
+			last_login_date datetime
+		);
+		create table if not exists user_session (
+			token varchar(64) not null primary key, -- 会话令牌的 SHA-256 摘要，不保存令牌本身
+			user varchar(64) not null,
+			created_date datetime default (datetime('now', 'localtime')),
+			expires_date datetime not null,
+			last_used_date datetime
+		);
+		create index if not exists user_session_user on user_session (user);
+		create table if not exists audit_log ( -- 谁在何时新增、修改、启停、执行或删除了哪个源码
+			id integer primary key autoincrement,
+			time datetime default (datetime('now', 'localtime')),
+			user varchar(64) not null default '', -- 未启用登录时为空
+			token varchar(64) not null default '', -- 通过 API 令牌操作时为令牌的名称
+			action varchar(16) not null, -- 如 create、edit、draft、publish、activate、deactivate、eval、delete
+			name varchar(64) not null default '',
+			type varchar(16) not null default '',
+			detail text not null default '',
+			remote_addr varchar(64) not null default ''
+		);
+		create index if not exists audit_log_source on audit_log (name, type, id);
+		create index if not exists audit_log_user on audit_log (user, id);
+		create table if not exists api_token ( -- 用于脚本和持续集成的 Bearer 令牌，权限不超过所有者的角色，并受 scopes 限制
+			id integer primary key autoincrement,
+			name varchar(64) not null,
+			token varchar(64) not null unique, -- 令牌的 SHA-256 摘要，不保存令牌本身
+			user varchar(64) not null,
+			scopes varchar(64) not null, -- read、write、eval、admin，以逗号分隔
+			created_date datetime default (datetime('now', 'localtime')),
+			expires_date datetime, -- 为空时永不过期
+			last_used_date datetime,
+			unique (user, name)
+		);
 	`)
 	if err != nil {
 		panic(err)
 	}
+
+	// 为旧版本创建的数据库补充新增的字段（SQLite 不校验 varchar 的长度，因此 cron 字段无需变更）
+	for _, c := range [][3]string{
+		{"source", "timeout", "integer not null default 0"},
+		{"source", "overlap", "varchar(8) not null default 'allow'"},
+		{"source", "retries", "integer not null default 0"},
+		{"source", "restart", "varchar(16) not null default 'never'"},
+		{"source", "source_map", "text not null default ''"},
+		{"source", "draft", "boolean not null default false"},
+		{"source", "draft_content", "text not null default ''"},
+		{"source", "draft_compiled", "text not null default ''"},
+		{"source", "draft_source_map", "text not null default ''"},
+		{"source", "draft_modified_date", "datetime"},
+		{"source", "draft_base_date", "datetime"},
+		{"crontab_run", "attempts", "integer not null default 1"},
+		{"audit_log", "token", "varchar(64) not null default ''"},
+	} {
+		if err := addColumn(c[0], c[1], c[2]); err != nil {
+			panic(err)
+		}
+	}
+
+	// 旧版本创建的数据库中没有全文索引，批量导入时按 rowid 替换的记录也可能残留在索引中，数量不一致时重建索引
+	if err := SyncSourceIndex(); err != nil {
+		panic(err)
+	}
+
+	// 旧版本创建的数据库中没有依赖关系，需要解析已有的源码
+	var count int
+	if err := Db.QueryRow("select count(1) from source_dependency").Scan(&count); err != nil {
+		panic(err)
+	}
+	if count == 0 {
+		if err := RebuildDependencies(); err != nil {
+			panic(err)
+		}
+	}
+
+	RefreshAuthEnabled()
+}
+
+// SyncSourceIndex 源码的数量与全文索引中的数量不一致时，重建全文索引
+func SyncSourceIndex() error {
+	var sources, indexed int
+	if err := Db.QueryRow("select (select count(1) from source), (select count(1) from source_fts)").Scan(&sources, &indexed); err != nil {
+		return err
+	}
+	if sources == indexed {
+		return nil
+	}
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("delete from source_fts"); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("insert into source_fts (name, type, content) select name, type, content from source"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 如果字段不存在，则新增字段
+func addColumn(table string, column string, definition string) error {
+	var count int
+	if err := Db.QueryRow("select count(1) from pragma_table_info(?) where name = ?", table, column).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := Db.Exec("alter table " + table + " add column " + column + " " + definition)
+	return err
 }
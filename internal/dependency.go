@@ -0,0 +1,418 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"cube/internal/model"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+	"github.com/go-sourcemap/sourcemap"
+)
+
+// 模块代码的闭包，与 compileModule 一致
+const moduleWrapper = "(function(exports, require, module) {"
+
+// 获取模块加载时实际运行的代码，与 compileModule 一致
+func moduleSource(lang string, content string, compiled string) string {
+	switch lang {
+	case "json": // JSON 模块导出解析后的值
+		return "module.exports = " + content + ";"
+	case "javascript": // JavaScript 模块无需编译
+		if compiled == "" {
+			return content
+		}
+	}
+	return compiled
+}
+
+// 源码是否作为模块加载，template 和 resource 不作为模块加载，无需校验语法和解析依赖
+func isModuleType(stype string) bool {
+	return stype == "module" || stype == "controller" || stype == "daemon" || stype == "crontab"
+}
+
+// SourceModuleId 获取源码被 require 时的模块 id，与 parseModuleId 相反，如 controller foo 对应 "./controller/foo"，node_modules/foo 对应 "foo"
+// template 和 resource 不作为模块加载，返回空字符串
+func SourceModuleId(name string, stype string) string {
+	switch stype {
+	case "module":
+		return moduleId(name)
+	case "controller", "daemon", "crontab":
+		return "./" + stype + "/" + name
+	}
+	return ""
+}
+
+// CheckSource 在保存源码时校验编译后代码的语法，与模块加载时的解析方式一致，存在源映射时异常中的位置为源码中的位置
+func CheckSource(name string, stype string, lang string, content string, compiled string, sourceMap string) error {
+	if !isModuleType(stype) {
+		return nil
+	}
+	if lang == "json" {
+		if !json.Valid([]byte(content)) {
+			return errors.New(name + ".json: invalid json")
+		}
+		return nil
+	}
+	src := moduleSource(lang, content, compiled)
+	if strings.TrimSpace(src) == "" {
+		return nil
+	}
+	parsed, err := parser.ParseFile(nil, name, moduleWrapper+src+"\n})", 0, parser.WithDisableSourceMaps)
+	if err != nil {
+		return syntaxError(err, sourceMap)
+	}
+	_, err = goja.CompileAST(parsed, false)
+	return err
+}
+
+// 去除语法错误位置中模块闭包的长度，并通过源映射还原为源码中的位置，格式与 util.Transpile 的编译错误一致，如 foo.ts:5:10: Unexpected token
+// 语法错误发生在 goja 加载源映射之前，因此需要单独处理
+func syntaxError(err error, sourceMap string) error {
+	list, ok := err.(parser.ErrorList)
+	if !ok || len(list) == 0 {
+		return err
+	}
+	line, column := list[0].Position.Line, list[0].Position.Column-1 // 列号从 1 开始，源映射中的列号从 0 开始
+	if line == 1 {
+		column -= len(moduleWrapper)
+	}
+	if sourceMap == "" {
+		return fmt.Errorf("%s:%d:%d: %s", list[0].Position.Filename, line, column+1, list[0].Message)
+	}
+	consumer, e := sourcemap.Parse("", []byte(sourceMap))
+	if e != nil {
+		return err
+	}
+	source, _, line, column, ok := consumer.Source(line, column)
+	if !ok {
+		return err
+	}
+	return fmt.Errorf("%s:%d:%d: %s", source, line, column+1, list[0].Message)
+}
+
+// ParseRequires 解析代码中静态 require 的模块，按出现的顺序返回去重后的参数
+// 静态的 require 调用的参数为字符串字面量，如 require("./lib/date")，TypeScript 中的 import 语句编译后即为该形式
+// 注释、字符串、模板字符串和正则表达式中的 require 不是调用，将被跳过
+func ParseRequires(src string) []string {
+	p := &requireParser{src: src, specs: make([]string, 0), seen: make(map[string]bool)}
+	p.parse(0, false)
+	return p.specs
+}
+
+type requireParser struct {
+	src   string
+	specs []string
+	seen  map[string]bool
+}
+
+// 解析代码直至结尾，nested 为 true 时解析模板字符串中的 ${} 表达式，返回表达式结束的 } 之后的位置
+func (p *requireParser) parse(i int, nested bool) int {
+	src := p.src
+	depth := 0
+	prev, word := byte(0), "" // 上一个有效字符及标识符，用于区分除号与正则表达式
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '/' && strings.HasPrefix(src[i:], "//"):
+			i = skipUntil(src, i+2, "\n")
+			continue
+		case c == '/' && strings.HasPrefix(src[i:], "/*"):
+			i = skipUntil(src, i+2, "*/")
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '"' || c == '\'' || c == '`':
+			i = p.skipString(i)
+		case c == '/' && regexpAllowed(prev, word):
+			i = skipRegexp(src, i)
+		case isIdentifierChar(c):
+			j := i
+			for j < len(src) && isIdentifierChar(src[j]) {
+				j++
+			}
+			if src[i:j] == "require" && prev != '.' {
+				p.parseCall(j)
+			}
+			prev, word, i = src[j-1], src[i:j], j
+			continue
+		default:
+			if c == '{' {
+				depth++
+			} else if c == '}' {
+				if nested && depth == 0 {
+					return i + 1
+				}
+				depth--
+			}
+			i++
+		}
+		prev, word = src[i-1], ""
+	}
+	return i
+}
+
+// 解析 require 之后的 ("module") 或 ('module')
+func (p *requireParser) parseCall(i int) {
+	src := p.src
+	i = skipSpace(src, i)
+	if i >= len(src) || src[i] != '(' {
+		return
+	}
+	i = skipSpace(src, i+1)
+	if i >= len(src) || (src[i] != '"' && src[i] != '\'') {
+		return
+	}
+	end := strings.IndexAny(src[i+1:], string(src[i])+"\n")
+	if end <= 0 || src[i+1+end] == '\n' {
+		return
+	}
+	spec := src[i+1 : i+1+end]
+	if j := skipSpace(src, i+2+end); j >= len(src) || src[j] != ')' {
+		return
+	}
+	if !p.seen[spec] {
+		p.seen[spec] = true
+		p.specs = append(p.specs, spec)
+	}
+}
+
+// 跳过字符串或模板字符串，模板字符串中的 ${} 表达式仍需解析
+func (p *requireParser) skipString(i int) int {
+	src, quote := p.src, p.src[i]
+	for i++; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '\\':
+			i++
+		case c == quote:
+			return i + 1
+		case c == '\n' && quote != '`': // 未闭合的字符串
+			return i
+		case c == '$' && quote == '`' && i+1 < len(src) && src[i+1] == '{':
+			i = p.parse(i+2, true) - 1
+		}
+	}
+	return i
+}
+
+// 跳过正则表达式字面量，字符集合中的 / 不是结束符
+func skipRegexp(src string, i int) int {
+	class := false
+	for i++; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '[':
+			class = true
+		case ']':
+			class = false
+		case '/':
+			if !class {
+				return i + 1
+			}
+		case '\n':
+			return i
+		}
+	}
+	return i
+}
+
+// 根据上一个有效字符或关键字判断 / 是否为正则表达式的开始，而不是除号
+func regexpAllowed(prev byte, word string) bool {
+	if word != "" {
+		switch word {
+		case "return", "typeof", "case", "do", "else", "in", "of", "new", "delete", "void", "throw", "instanceof", "yield", "await":
+			return true
+		}
+		return false
+	}
+	return prev == 0 || strings.IndexByte("(,=:[!&|?{};+-*%<>~^", prev) >= 0
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= utf8.RuneSelf
+}
+
+func skipSpace(src string, i int) int {
+	for i < len(src) && strings.IndexByte(" \t\r\n", src[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+func skipUntil(src string, i int, end string) int {
+	if n := strings.Index(src[i:], end); n >= 0 {
+		return i + n + len(end)
+	}
+	return len(src)
+}
+
+// UpdateDependencies 解析源码静态 require 的模块，并更新依赖关系，在已发布的源码变更后调用
+func UpdateDependencies(name string, stype string) error {
+	var lang, content, compiled string
+	err := Db.QueryRow("select lang, content, compiled from source where name = ? and type = ?", name, stype).Scan(&lang, &content, &compiled)
+	if err != nil {
+		return err
+	}
+
+	tx, err := Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("delete from source_dependency where name = ? and type = ?", name, stype); err != nil {
+		return err
+	}
+	if isModuleType(stype) && lang != "json" {
+		parent := SourceModuleId(name, stype)
+		for _, spec := range ParseRequires(moduleSource(lang, content, compiled)) {
+			id, err := resolveModuleId(parent, spec)
+			if err != nil { // 无法解析的路径在运行时同样会失败，这里忽略
+				continue
+			}
+			if _, err := tx.Exec("insert or ignore into source_dependency (name, type, module) values (?, ?, ?)", name, stype, id); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// RebuildDependencies 重新解析所有源码的依赖关系，在启动时调用，以便补充旧版本创建的数据库中的依赖关系
+func RebuildDependencies() error {
+	rows, err := Db.Query("select name, type from source where type in ('module', 'controller', 'daemon', 'crontab')")
+	if err != nil {
+		return err
+	}
+	sources := make([][2]string, 0)
+	for rows.Next() {
+		var name, stype string
+		if err := rows.Scan(&name, &stype); err != nil {
+			rows.Close()
+			return err
+		}
+		sources = append(sources, [2]string{name, stype})
+	}
+	rows.Close()
+
+	for _, s := range sources {
+		if err := UpdateDependencies(s[0], s[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDependencies 获取源码依赖的模块，recursive 为 true 时包括间接依赖的模块
+func GetDependencies(name string, stype string, recursive bool) ([]model.Dependency, error) {
+	dependencies := make([]model.Dependency, 0)
+	seen := map[string]bool{SourceModuleId(name, stype): true}
+	queue := [][2]string{{name, stype}}
+	for len(queue) > 0 {
+		rows, err := Db.Query("select module from source_dependency where name = ? and type = ? order by module", queue[0][0], queue[0][1])
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, 0)
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		queue = queue[1:]
+
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			d, err := getDependency(id)
+			if err != nil {
+				return nil, err
+			}
+			dependencies = append(dependencies, d)
+			if recursive && d.Exists && d.Type != "link" {
+				queue = append(queue, [2]string{d.Name, d.Type})
+			}
+		}
+	}
+	return dependencies, nil
+}
+
+// 根据模块 id 查找对应的源码，模块可以省略 index，如 "./lib" 对应 lib 或 lib/index
+func getDependency(id string) (model.Dependency, error) {
+	name, stype := parseModuleId(id)
+	d := model.Dependency{Module: id, Name: name, Type: stype}
+	if stype == "link" {
+		var count int
+		if err := Db.QueryRow("select count(1) from remote_module where url = ?", name).Scan(&count); err != nil {
+			return d, err
+		}
+		d.Exists, d.Active = count > 0, count > 0
+		return d, nil
+	}
+	candidates := []string{name}
+	if stype == "module" {
+		candidates = append(candidates, name+"/index")
+	}
+	for _, candidate := range candidates {
+		var active bool
+		err := Db.QueryRow("select active from source where name = ? and type = ?", candidate, stype).Scan(&active)
+		if err == nil {
+			d.Name, d.Exists, d.Active = candidate, true, active
+			break
+		}
+	}
+	return d, nil
+}
+
+// CheckDependents 停用或删除已启用的源码前，校验是否仍有已启用的源码直接 require 它，这些源码此后将无法加载
+// 未指定 force 时拒绝操作，并在异常中列出这些源码
+func CheckDependents(name string, stype string) error {
+	dependents, err := GetDependents(name, stype)
+	if err != nil {
+		return err
+	}
+	importers := make([]string, 0)
+	for _, d := range dependents {
+		if d.Active && (d.Name != name || d.Type != stype) {
+			importers = append(importers, d.Type+" "+d.Name)
+		}
+	}
+	if len(importers) == 0 {
+		return nil
+	}
+	return errors.New(name + " is still imported by " + strings.Join(importers, ", ") + ", which will fail to load it; use force to proceed")
+}
+
+// GetDependents 获取直接 require 了指定模块的源码
+func GetDependents(name string, stype string) ([]model.Dependency, error) {
+	ids := []string{SourceModuleId(name, stype)}
+	if stype == "module" && strings.HasSuffix(name, "/index") { // 如 lib/index 也可以通过 "./lib" 加载
+		ids = append(ids, moduleId(strings.TrimSuffix(name, "/index")))
+	}
+	rows, err := Db.Query("select d.name, d.type, s.active from source_dependency d join source s on s.name = d.name and s.type = d.type where d.module in (?, ?) order by d.type, d.name", ids[0], ids[len(ids)-1])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	dependents := make([]model.Dependency, 0)
+	for rows.Next() {
+		d := model.Dependency{Exists: true}
+		if err := rows.Scan(&d.Name, &d.Type, &d.Active); err != nil {
+			return nil, err
+		}
+		d.Module = SourceModuleId(d.Name, d.Type)
+		dependents = append(dependents, d)
+	}
+	return dependents, rows.Err()
+}
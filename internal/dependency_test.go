@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRequires(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected []string
+	}{
+		{`const a = require("./a"), b = require('./b')`, []string{"./a", "./b"}},
+		{"require( \"./a\" )\nrequire(\n  './a'\n)", []string{"./a"}},
+		{`foo.require("./a"); myrequire("./b"); $require("./c")`, []string{}},
+		{`require(name); require("./a" + x); require("")`, []string{}},
+		{"// require(\"./a\")\n/* require(\"./b\")\n */ require(\"./c\")", []string{"./c"}},
+		{`const s = "require('./a')", t = 'require("./b")' + require("./c")`, []string{"./c"}},
+		{`const s = "\"; require('./a'); \""; require("./b")`, []string{"./b"}},
+		{"const s = `require(\"./a\") ${require(\"./b\")} ${`${require(\"./c\")}`}`", []string{"./b", "./c"}},
+		{"const re = /require(\"[./]a\")/g; const n = a / 2, m = b / require(\"./b\")", []string{"./b"}},
+		{"if (/\"/.test(s)) require(\"./a\")\nfunction f() { return /'/ }\nrequire(\"./b\")", []string{"./a", "./b"}},
+		{`const x = { a: 1 }; require("./a")`, []string{"./a"}},
+		{"const s = \"require('./a')\nrequire(\"./b\")", []string{"./b"}}, // 未闭合的字符串在行尾结束
+	}
+	for _, test := range tests {
+		if specs := ParseRequires(test.src); !reflect.DeepEqual(specs, test.expected) {
+			t.Errorf("ParseRequires(%q) = %q, expected %q", test.src, specs, test.expected)
+		}
+	}
+}
+
+func TestCheckSource(t *testing.T) {
+	tests := []struct {
+		lang     string
+		content  string
+		expected string // 错误信息的前缀，空字符串表示没有错误
+	}{
+		{"javascript", "exports.a = 1", ""},
+		{"javascript", "", ""},
+		{"javascript", "let a = ;", "foo:1:9: "},
+		{"javascript", "  let a = ;", "foo:1:11: "},
+		{"javascript", "let a = 1\nlet b = ;", "foo:2:9: "},
+		{"javascript", "let a = 1; let b = ;", "foo:1:20: "},
+		{"json", `{"a": 1}`, ""},
+		{"json", `{"a": }`, "foo.json: invalid json"},
+	}
+	for _, test := range tests {
+		err := CheckSource("foo", "module", test.lang, test.content, "", "")
+		if test.expected == "" && err != nil {
+			t.Errorf("CheckSource(%q) = %v, expected no error", test.content, err)
+		}
+		if test.expected != "" && (err == nil || !strings.HasPrefix(err.Error(), test.expected)) {
+			t.Errorf("CheckSource(%q) = %v, expected %q", test.content, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"cube/internal/log"
+	"cube/internal/util"
+)
+
+type accessEntryKey struct{}
+
+// 客户端传入的请求 ID 仅允许可见的 ASCII 字符，防止日志注入
+var requestIdPattern = regexp.MustCompile(`^[\x21-\x7e]{1,128}$`)
+
+// 记录访问日志，并生成或透传请求 ID
+func accessLog(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		// 请求 ID 写入请求头，以便后续通过 ServiceContext 获取
+		id := r.Header.Get("X-Request-Id")
+		if !requestIdPattern.MatchString(id) {
+			id = util.Ulid()
+			r.Header.Set("X-Request-Id", id)
+		}
+		w.Header().Set("X-Request-Id", id)
+
+		entry := &log.AccessEntry{
+			Time:       start,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Proto:      r.Proto,
+			RemoteAddr: r.RemoteAddr,
+			Worker:     -1,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			RequestId:  id,
+		}
+		aw := &accessLogResponseWriter{ResponseWriter: w}
+
+		defer func() {
+			entry.Status, entry.Bytes = aw.status, aw.bytes
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
+			}
+			entry.Latency = float64(time.Since(start).Microseconds()) / 1000
+			log.Access(entry)
+		}()
+
+		next(aw, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+	}
+}
+
+// 在访问日志中记录匹配到的 controller 和执行的 worker
+func setAccessEntry(r *http.Request, controller string, worker int) {
+	if entry, ok := r.Context().Value(accessEntryKey{}).(*log.AccessEntry); ok {
+		entry.Controller, entry.Worker = controller, worker
+	}
+}
+
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// 以下方法用于保留原始 ResponseWriter 的能力，如 chunk 响应、WebSocket 升级、服务端推送
+
+func (w *accessLogResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("failed to get an http hijacker")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (w *accessLogResponseWriter) Push(target string, opts *http.PushOptions) error {
+	p, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return p.Push(target, opts)
+}
+
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"cube/internal"
+	"cube/internal/model"
+	"cube/internal/util"
+)
+
+func HandleCrontab(w http.ResponseWriter, r *http.Request) {
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		if r.URL.Query().Has("cron") {
+			data, err = handleCrontabPreview(r)
+		} else {
+			data, err = handleCrontabGet(r)
+		}
+	case http.MethodPost:
+		err = handleCrontabPost(r)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+// 分页查询定时任务的执行历史，按时间倒序返回
+func handleCrontabGet(r *http.Request) (interface{}, error) {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	name := p.Get("name")
+	from, size := p.GetIntOrDefault("from", 0), p.GetIntOrDefault("size", 10)
+
+	var data struct {
+		Runs  []model.CrontabRun `json:"runs"`
+		Total int                `json:"total"`
+	}
+	data.Runs = make([]model.CrontabRun, 0, size)
+
+	if err := internal.Db.QueryRow("select count(1) from crontab_run where name = ?", name).Scan(&data.Total); err != nil {
+		return nil, err
+	}
+
+	rows, err := internal.Db.Query("select id, name, manual, status, start_time, end_time, duration, error, result, attempts from crontab_run where name = ? order by id desc limit ?, ?", name, from, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var run model.CrontabRun
+		if err := rows.Scan(&run.Id, &run.Name, &run.Manual, &run.Status, &run.StartTime, &run.EndTime, &run.Duration, &run.Error, &run.Result, &run.Attempts); err != nil {
+			return nil, err
+		}
+		data.Runs = append(data.Runs, run)
+	}
+
+	return data, rows.Err()
+}
+
+// 预览 cron 表达式接下来的触发时间
+func handleCrontabPreview(r *http.Request) (interface{}, error) {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	count := p.GetIntOrDefault("count", 5)
+	if count < 1 || count > 100 {
+		return nil, errors.New("count must be between 1 and 100")
+	}
+
+	schedule, err := util.ParseCron(p.Get("cron"))
+	if err != nil {
+		return nil, err
+	}
+
+	times, t := make([]util.Time, 0, count), time.Now()
+	for i := 0; i < count; i++ {
+		if t = schedule.Next(t); t.IsZero() { // 表达式无法匹配任何时间，例如 2 月 30 日
+			break
+		}
+		times = append(times, util.Time(t))
+	}
+	return times, nil
+}
+
+// 立即执行一次定时任务
+func handleCrontabPost(r *http.Request) error {
+	name := r.URL.Query().Get("name")
+
+	var count int
+	if err := internal.Db.QueryRow("select count(1) from source where name = ? and type = 'crontab' and active = true", name).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("crontab not found or inactive")
+	}
+
+	audit(r, "run", name, "crontab", "")
+	go internal.RunCrontab(name, true) // 异步执行，执行结果通过执行历史查询
+	return nil
+}
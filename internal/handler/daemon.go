@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"cube/internal/module"
+	"cube/internal/util"
+)
+
+func HandleDaemon(w http.ResponseWriter, r *http.Request) {
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodPost:
+		data, err = handleDaemonPost(r)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+// 向运行中的 daemon 发送消息（请求体为 JSON，可为空），并返回 daemon 的回复
+func handleDaemonPost(r *http.Request) (interface{}, error) {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	name := p.Get("name")
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	body, err := util.StringWithIoReader(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	var message interface{}
+	if body != "" {
+		if err := json.Unmarshal([]byte(body), &message); err != nil {
+			return nil, errors.New("message must be a valid json")
+		}
+	}
+
+	return module.SendDaemonMessage(name, message, time.Duration(p.GetIntOrDefault("timeout", 0))*time.Millisecond, nil)
+}
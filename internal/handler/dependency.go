@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"cube/internal"
+	"cube/internal/util"
+)
+
+func HandleDependency(w http.ResponseWriter, r *http.Request) {
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		data, err = handleDependencyGet(r)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+// 查询源码依赖的模块（指定 recursive 时包括间接依赖），以及直接 require 了该源码的其他源码
+func handleDependencyGet(r *http.Request) (interface{}, error) {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	name, stype := p.Get("name"), p.Get("type")
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if stype == "" {
+		return nil, errors.New("type is required")
+	}
+
+	dependencies, err := internal.GetDependencies(name, stype, p.Has("recursive"))
+	if err != nil {
+		return nil, err
+	}
+	dependents, err := internal.GetDependents(name, stype)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"dependencies": dependencies,
+		"dependents":   dependents,
+	}, nil
+}
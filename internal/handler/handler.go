@@ -1,15 +1,20 @@
 package handler
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/fs"
 	"net/http"
+	"slices"
+	"strconv"
 	"strings"
 
+	"cube/internal"
 	"cube/internal/builtin"
-	"cube/internal/config"
+	"cube/internal/model"
 	"cube/internal/util"
 
 	"github.com/dop251/goja"
@@ -17,12 +22,30 @@ import (
 
 func InitHandle(web *embed.FS) {
 	// 运行态
-	http.HandleFunc("/service/", HandleService)
-	http.HandleFunc("/resource/", HandleResource)
+	http.HandleFunc("/service/", accessLog(HandleService))
+	http.HandleFunc("/resource/", accessLog(HandleResource))
+
+	// 探针
+	http.HandleFunc("/healthz", HandleHealthz)
+	http.HandleFunc("/readyz", HandleReadyz)
 
 	// 开发态
-	http.HandleFunc("/source", authenticate(HandleSource))
-	http.HandleFunc("/document/", authenticate(HandleDocument))
+	http.HandleFunc("/source", authenticate("viewer", "developer", HandleSource)) // 按请求方法和源码类型进一步校验
+	http.HandleFunc("/document/", authenticate("viewer", "viewer", HandleDocument))
+	http.HandleFunc("/log", authenticate("viewer", "publisher", HandleLog))
+	http.HandleFunc("/metrics", authenticate("viewer", "viewer", HandleMetrics))
+	http.HandleFunc("/crontab", authenticate("viewer", "publisher", HandleCrontab))
+	http.HandleFunc("/runtime", authenticate("viewer", "publisher", HandleRuntime))
+	http.HandleFunc("/daemon", authenticate("viewer", "publisher", HandleDaemon))
+	http.HandleFunc("/remote", authenticate("viewer", "publisher", HandleRemote))
+	http.HandleFunc("/package", authenticate("viewer", "publisher", HandlePackage))
+	http.HandleFunc("/history", authenticate("viewer", "publisher", HandleHistory))
+	http.HandleFunc("/dependency", authenticate("viewer", "viewer", HandleDependency))
+	http.HandleFunc("/search", authenticate("viewer", "developer", HandleSearch)) // 替换须具备 publisher 角色，预览除外
+	http.HandleFunc("/preview/service/", authenticate("developer", "developer", accessLog(HandlePreviewService)))
+	http.HandleFunc("/user", HandleUser) // 登录无需认证，其余操作在内部校验
+	http.HandleFunc("/audit", authenticate("admin", "admin", HandleAudit))
+	http.HandleFunc("/token", authenticate("viewer", "viewer", HandleToken)) // 管理自己的令牌，admin 可管理所有令牌
 
 	fileList, _ := fs.Sub(web, "web")
 	fileServer := http.FileServer(http.FS(fileList))
@@ -95,23 +118,115 @@ func Error(w http.ResponseWriter, data interface{}) {
 	}
 }
 
-func authenticate(next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+type userKey struct{}
+
+// 会话令牌所在的 cookie
+const sessionCookie = "cube_session"
+
+// 校验登录状态和角色：GET、HEAD 请求须具备 read 角色，其余请求须具备 write 角色；未创建任何账号时不校验
+// 请求头中携带 Authorization: Bearer <token> 时以 API 令牌的所有者校验，且须具备相应的 scope
+func authenticate(read string, write string, next func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// 如果未配置用户名密码，直接执行
-		if config.IdeAuthorization == "" {
+		// 如果未创建账号，直接执行
+		if !internal.AuthEnabled() {
 			next(w, r)
 			return
 		}
 
-		// 校验用户名密码
-		a := &util.DigestAuth{}
-		if a.VerifyWithMd5(r.Header.Get("Authorization"), r.Method, config.IdeAuthorization) {
-			next(w, r)
+		// 校验 API 令牌或会话
+		var (
+			user *model.User
+			err  error
+		)
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			user, err = internal.VerifyToken(token)
+		} else {
+			var token string
+			if c, err := r.Cookie(sessionCookie); err == nil {
+				token = c.Value
+			}
+			user, err = internal.VerifySession(token)
+		}
+		if err != nil {
+			deny(w, http.StatusUnauthorized, err.Error())
 			return
 		}
 
-		// 用户名密码校验不通过
-		w.Header().Set("WWW-Authenticate", "Digest nonce=\""+a.Random(16)+"\", opaque=\""+a.Random(16)+"\", qop=\"auth\"")
-		w.WriteHeader(http.StatusUnauthorized)
+		// 校验角色
+		role := write
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			role = read
+		}
+		if !internal.HasRole(user.Role, role) {
+			deny(w, http.StatusForbidden, "permission denied, "+role+" role required")
+			return
+		}
+		if scope := tokenScope(r, role); user.Token != "" && !slices.Contains(user.Scopes, scope) {
+			deny(w, http.StatusForbidden, "permission denied, token scope "+scope+" required")
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
+	}
+}
+
+// 请求所需的令牌 scope：执行脚本和预览草稿须具备 eval，须 admin 角色的接口和管理令牌须具备 admin，其余按请求方法区分 read 和 write
+func tokenScope(r *http.Request, role string) string {
+	switch {
+	case r.Method == "EVAL" || strings.HasPrefix(r.URL.Path, "/preview/"):
+		return "eval"
+	case role == "admin" || r.URL.Path == "/token":
+		return "admin"
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return "read"
+	}
+	return "write"
+}
+
+// 拒绝访问，返回与 Error 相同格式的响应，以便 IDE 显示原因
+func deny(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code":    strconv.Itoa(status),
+		"message": message,
+	})
+}
+
+// 当前登录的用户，未创建任何账号时为 nil
+func currentUser(r *http.Request) *model.User {
+	user, _ := r.Context().Value(userKey{}).(*model.User)
+	return user
+}
+
+// 校验当前用户的角色，未创建任何账号时不校验；通过 API 令牌访问时，须 admin 角色的操作还须具备 admin scope
+func requireRole(r *http.Request, role string) error {
+	user := currentUser(r)
+	if user == nil {
+		return nil
+	}
+	if !internal.HasRole(user.Role, role) {
+		return errors.New("permission denied, " + role + " role required")
+	}
+	if role == "admin" && user.Token != "" && !slices.Contains(user.Scopes, "admin") {
+		return errors.New("permission denied, token scope admin required")
+	}
+	return nil
+}
+
+// 校验当前用户是否可以修改指定类型的源码
+func requireSourceWrite(r *http.Request, stype string) error {
+	if user := currentUser(r); user != nil && !internal.CanWriteSource(user.Role, stype) {
+		return errors.New("permission denied, " + user.Role + " can not modify " + stype)
+	}
+	return nil
+}
+
+// 以当前用户记录审计日志
+func audit(r *http.Request, action string, name string, stype string, detail string) {
+	entry := model.AuditLog{Action: action, Name: name, Type: stype, Detail: detail, RemoteAddr: r.RemoteAddr}
+	if u := currentUser(r); u != nil {
+		entry.User, entry.Token = u.Name, u.Token
 	}
+	internal.Audit(entry)
 }
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"cube/internal"
+	"cube/internal/cache"
+)
+
+func TestMain(m *testing.M) {
+	internal.InitDb("")
+	if err := cache.Init(internal.Db); err != nil {
+		panic(err)
+	}
+	InitHandle(&embed.FS{})
+	for _, role := range internal.Roles {
+		hash, err := internal.HashPassword(role + "-password")
+		if err != nil {
+			panic(err)
+		}
+		if _, err := internal.Db.Exec("insert into user (name, password, role) values (?, ?, ?)", role, hash, role); err != nil {
+			panic(err)
+		}
+	}
+	internal.RefreshAuthEnabled()
+	os.Exit(m.Run())
+}
+
+// 以会话登录，返回设置会话 cookie 的函数
+func session(t *testing.T, name string) func(r *http.Request) {
+	token, _, err := internal.Login(name, name+"-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+	}
+}
+
+// 发送请求，返回响应的状态码和错误信息
+func request(method string, target string, body string, auth func(r *http.Request)) (int, string) {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if auth != nil {
+		auth(r)
+	}
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, r)
+	var res struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &res)
+	return w.Code, res.Message
+}
+
+// 校验请求被拒绝时的状态码和错误信息，denied 为空时校验请求未被拒绝
+func expectDenied(t *testing.T, method string, target string, body string, auth func(r *http.Request), status int, denied string) {
+	t.Helper()
+	code, message := request(method, target, body, auth)
+	if denied == "" {
+		if code == http.StatusUnauthorized || code == http.StatusForbidden || strings.Contains(message, "permission denied") {
+			t.Errorf("%s %s: %d %s, expected to be allowed", method, target, code, message)
+		}
+		return
+	}
+	if code != status || !strings.Contains(message, denied) {
+		t.Errorf("%s %s: %d %s, expected %d %s", method, target, code, message, status, denied)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	viewer, developer, publisher, admin := session(t, "viewer"), session(t, "developer"), session(t, "publisher"), session(t, "admin")
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		auth   func(r *http.Request)
+		status int
+		denied string
+	}{
+		{"GET", "/source?type=module", "", nil, 401, "login required"},
+		{"GET", "/source?type=module", "", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "unknown"}) }, 401, "login required"},
+		{"GET", "/source?type=module", "", viewer, 0, ""},
+		{"GET", "/user?me", "", viewer, 0, ""},
+
+		// viewer 只读
+		{"POST", "/source", `{"name":"foo","type":"module","lang":"typescript"}`, viewer, 403, "developer role required"},
+		{"PUT", "/source", `{"name":"foo","type":"module"}`, viewer, 403, "developer role required"},
+		{"DELETE", "/source?name=foo&type=module", "", viewer, 403, "developer role required"},
+		{"POST", "/search", `{"query":"a","replacement":"b"}`, viewer, 403, "developer role required"},
+
+		// developer 不能修改 daemon 和 crontab
+		{"POST", "/source", `{"name":"foo","type":"daemon","lang":"typescript"}`, developer, 400, "developer can not modify daemon"},
+		{"POST", "/source", `{"name":"foo","type":"crontab","lang":"typescript","cron":"* * * * *"}`, developer, 400, "developer can not modify crontab"},
+		{"POST", "/daemon?name=foo", "", developer, 403, "publisher role required"},
+		{"POST", "/crontab?name=foo", "", developer, 403, "publisher role required"},
+		{"POST", "/source", `{"name":"dev_module","type":"module","lang":"typescript"}`, developer, 0, ""},
+		{"POST", "/source", `{"name":"foo","type":"daemon","lang":"typescript","restart":"never"}`, publisher, 0, ""},
+
+		// 管理账号和查看审计日志须具备 admin 角色
+		{"GET", "/user", "", publisher, 400, "admin role required"},
+		{"GET", "/audit", "", publisher, 403, "admin role required"},
+		{"GET", "/user", "", admin, 0, ""},
+		{"GET", "/audit", "", admin, 0, ""},
+	}
+	for _, test := range tests {
+		expectDenied(t, test.method, test.target, test.body, test.auth, test.status, test.denied)
+	}
+}
+
+func TestExpiredSession(t *testing.T) {
+	auth := session(t, "viewer")
+	expectDenied(t, "GET", "/source?type=module", "", auth, 0, "")
+	if _, err := internal.Db.Exec("update user_session set expires_date = datetime('now', 'localtime', '-1 minute') where user = 'viewer'"); err != nil {
+		t.Fatal(err)
+	}
+	expectDenied(t, "GET", "/source?type=module", "", auth, 401, "login required")
+
+	// 停用账号后会话失效
+	auth = session(t, "developer")
+	if _, err := internal.Db.Exec("update user set active = false where name = 'developer'"); err != nil {
+		t.Fatal(err)
+	}
+	defer internal.Db.Exec("update user set active = true where name = 'developer'")
+	expectDenied(t, "GET", "/source?type=module", "", auth, 401, "login required")
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"cube/internal"
+	"cube/internal/cache"
+	"cube/internal/model"
+	"cube/internal/util"
+)
+
+func HandleHistory(w http.ResponseWriter, r *http.Request) {
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		if _, diff := r.URL.Query()["diff"]; !diff {
+			data, err = handleHistoryGet(r)
+		} else {
+			data, err = handleHistoryDiff(r)
+		}
+	case http.MethodPost:
+		data, err = handleHistoryRollback(r)
+	case http.MethodDelete:
+		data, err = handleHistoryDelete(r)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+const historyColumns = "id, action, name, type, lang, content, compiled, source_map, active, method, url, cron, tag, timeout, overlap, retries, restart, length(cast(content as blob)), last_modified_date, created_date"
+
+func scanHistory(row interface{ Scan(...interface{}) error }) (model.SourceHistory, error) {
+	var h model.SourceHistory
+	err := row.Scan(&h.Id, &h.Action, &h.Name, &h.Type, &h.Lang, &h.Content, &h.Compiled, &h.SourceMap, &h.Active, &h.Method, &h.Url, &h.Cron, &h.Tag, &h.Timeout, &h.Overlap, &h.Retries, &h.Restart, &h.Size, &h.LastModifiedDate, &h.CreatedDate)
+	return h, err
+}
+
+func getHistory(id int) (model.SourceHistory, error) {
+	h, err := scanHistory(internal.Db.QueryRow("select "+historyColumns+" from source_history where id = ?", id))
+	if err == sql.ErrNoRows {
+		return h, errors.New("version does not exist")
+	}
+	return h, err
+}
+
+// 指定 id 时返回该版本的完整内容，否则按时间倒序分页查询源码的历史版本（不包含源码内容）
+func handleHistoryGet(r *http.Request) (interface{}, error) {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	if p.Has("id") {
+		return getHistory(p.GetIntOrDefault("id", 0))
+	}
+
+	name, stype := p.Get("name"), p.Get("type")
+	if name == "" {
+		return nil, errors.New("name is required")
+	}
+	if stype == "" {
+		return nil, errors.New("type is required")
+	}
+	from, size := p.GetIntOrDefault("from", 0), p.GetIntOrDefault("size", 10)
+
+	var data struct {
+		Histories []model.SourceHistory `json:"histories"`
+		Total     int                   `json:"total"`
+	}
+	data.Histories = make([]model.SourceHistory, 0, size)
+
+	if err := internal.Db.QueryRow("select count(1) from source_history where name = ? and type = ?", name, stype).Scan(&data.Total); err != nil {
+		return nil, err
+	}
+
+	columns := "id, action, name, type, lang, '', '', '', active, method, url, cron, tag, timeout, overlap, retries, restart, length(cast(content as blob)), last_modified_date, created_date"
+	rows, err := internal.Db.Query("select "+columns+" from source_history where name = ? and type = ? order by id desc limit ?, ?", name, stype, from, size)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		h, err := scanHistory(rows)
+		if err != nil {
+			return nil, err
+		}
+		data.Histories = append(data.Histories, h)
+	}
+	return data, rows.Err()
+}
+
+// 比较两个版本的源码，返回统一格式的差异，未指定 to 时与源码的当前内容比较
+func handleHistoryDiff(r *http.Request) (interface{}, error) {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	if !p.Has("from") {
+		return nil, errors.New("from is required")
+	}
+
+	from, err := getHistory(p.GetIntOrDefault("from", 0))
+	if err != nil {
+		return nil, err
+	}
+	fromName := from.Name + "@" + strconv.Itoa(from.Id)
+
+	var to, toName string
+	if p.Get("to") != "" {
+		h, err := getHistory(p.GetIntOrDefault("to", 0))
+		if err != nil {
+			return nil, err
+		}
+		to, toName = h.Content, h.Name+"@"+strconv.Itoa(h.Id)
+	} else {
+		if err := internal.Db.QueryRow("select content from source where name = ? and type = ?", from.Name, from.Type).Scan(&to); err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		toName = from.Name
+	}
+
+	return util.UnifiedDiff(from.Content, to, fromName, toName, p.GetIntOrDefault("context", 3)), nil
+}
+
+// 将源码回滚到指定的版本，回滚本身也将作为一个新版本记录
+// 源码已被删除时，以未启用的状态重新创建
+func handleHistoryRollback(r *http.Request) (interface{}, error) {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	if !p.Has("id") {
+		return nil, errors.New("id is required")
+	}
+	h, err := getHistory(p.GetIntOrDefault("id", 0))
+	if err != nil {
+		return nil, err
+	}
+	if err := requireSourceWrite(r, h.Type); err != nil {
+		return nil, err
+	}
+
+	var active bool
+	switch err := internal.Db.QueryRow("select active from source where name = ? and type = ?", h.Name, h.Type).Scan(&active); err {
+	case sql.ErrNoRows:
+		if _, err := internal.Db.Exec("insert into source (name, type, lang, content, compiled, source_map, active, method, url, cron, timeout, overlap, retries, restart, tag, last_modified_date) values(?, ?, ?, ?, ?, ?, false, ?, ?, ?, ?, ?, ?, ?, ?, datetime('now', 'localtime'))", h.Name, h.Type, h.Lang, h.Content, h.Compiled, h.SourceMap, h.Method, h.Url, h.Cron, h.Timeout, h.Overlap, h.Retries, h.Restart, h.Tag); err != nil {
+			return nil, err
+		}
+	case nil:
+		// 校验 url 不能重复
+		if active && (h.Type == "controller" || h.Type == "resource") {
+			var count int
+			if err := internal.Db.QueryRow("select count(1) from source where type = ? and url = ? and active = true and name != ?", h.Type, h.Url, h.Name).Scan(&count); err != nil {
+				return nil, err
+			}
+			if count > 0 {
+				return nil, errors.New("url already existed")
+			}
+		}
+		if _, err := internal.Db.Exec("update source set lang = ?, content = ?, compiled = ?, source_map = ?, method = ?, url = ?, cron = ?, timeout = ?, overlap = ?, retries = ?, restart = ?, tag = ?, last_modified_date = datetime('now', 'localtime') where name = ? and type = ?", h.Lang, h.Content, h.Compiled, h.SourceMap, h.Method, h.Url, h.Cron, h.Timeout, h.Overlap, h.Retries, h.Restart, h.Tag, h.Name, h.Type); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	// cron 表达式可能已改变，需要重新调度
+	if id, ok := cache.Crontab.Get(h.Name); ok && h.Type == "crontab" {
+		internal.Crontab.Remove(id)
+		cache.Crontab.Remove(h.Name)
+	}
+	return refreshSource(r, h.Name, h.Type, nil, "rollback", "version: "+strconv.Itoa(h.Id))
+}
+
+// 删除源码的历史版本，指定 id 时仅删除该版本，否则删除指定源码的所有版本
+func handleHistoryDelete(r *http.Request) (interface{}, error) {
+	// 历史版本是追溯修改的依据，须具备 admin 角色
+	if err := requireRole(r, "admin"); err != nil {
+		return nil, err
+	}
+	p := &util.QueryParams{Values: r.URL.Query()}
+
+	var (
+		res sql.Result
+		err error
+	)
+	if p.Has("id") {
+		res, err = internal.Db.Exec("delete from source_history where id = ?", p.GetIntOrDefault("id", 0))
+	} else {
+		name, stype := p.Get("name"), p.Get("type")
+		if name == "" || stype == "" {
+			return nil, errors.New("id or name and type is required")
+		}
+		res, err = internal.Db.Exec("delete from source_history where name = ? and type = ?", name, stype)
+	}
+	if err != nil {
+		return nil, err
+	}
+	count, _ := res.RowsAffected()
+	return map[string]interface{}{
+		"count": count,
+	}, nil
+}
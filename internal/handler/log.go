@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"cube/internal/log"
+	"cube/internal/util"
+)
+
+type logFilter struct {
+	level     int
+	source    string
+	stype     string
+	requestId string
+	keyword   string
+}
+
+func (f *logFilter) match(e *log.Entry) bool {
+	if log.Levels[e.Level] < f.level {
+		return false
+	}
+	if f.source != "" && e.Source != f.source {
+		return false
+	}
+	if f.stype != "" && e.Type != f.stype {
+		return false
+	}
+	if f.requestId != "" && e.RequestId != f.requestId {
+		return false
+	}
+	if f.keyword != "" && !strings.Contains(e.Message, f.keyword) {
+		return false
+	}
+	return true
+}
+
+func HandleLog(w http.ResponseWriter, r *http.Request) {
+	// 解析 URL 入参
+	p := &util.QueryParams{Values: r.URL.Query()}
+	filter := &logFilter{
+		level:     log.Levels[p.Get("level")],
+		source:    p.Get("source"),
+		stype:     p.Get("type"),
+		requestId: p.Get("request_id"),
+		keyword:   p.Get("keyword"),
+	}
+
+	// 查询 daemon 或 crontab 单独缓存的日志
+	buffered := p.Has("buffer") || r.Method == http.MethodDelete
+	if buffered && !log.Buffered(filter.stype, filter.source) {
+		Error(w, errors.New("log buffer is only available for a daemon or crontab when enabled by -log-buffer"))
+		return
+	}
+
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		if p.Has("tail") {
+			handleLogTail(w, r, filter, buffered)
+			return
+		}
+		if buffered {
+			data = handleLogBuffer(filter, p.GetIntOrDefault("from", 0), p.GetIntOrDefault("size", 50))
+		} else {
+			data, err = handleLogQuery(filter, p.GetIntOrDefault("from", 0), p.GetIntOrDefault("size", 50))
+		}
+	case http.MethodDelete:
+		err = log.ClearBuffer(filter.stype, filter.source)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+type logPage struct {
+	Entries []*log.Entry `json:"entries"`
+	Total   int          `json:"total"`
+}
+
+// 日志文件的查询结果不统计总数，统计须遍历全部文件，仅返回是否还有更早的日志
+type logFilePage struct {
+	Entries []*log.Entry `json:"entries"`
+	HasMore bool         `json:"has_more"`
+}
+
+// 分页查询日志，按时间倒序返回
+// 从最新的文件末尾开始逆序遍历，收集到 from + size 条匹配的日志后即停止，以免每次查询都读取全部的日志文件
+func handleLogQuery(filter *logFilter, from int, size int) (interface{}, error) {
+	data := logFilePage{Entries: make([]*log.Entry, 0, size)}
+	index := 0
+	files := log.Files()
+	for i := len(files) - 1; i >= 0; i-- {
+		next, err := scanLogEntriesReverse(files[i], func(e *log.Entry) bool {
+			if !filter.match(e) {
+				return true
+			}
+			if index >= from+size {
+				data.HasMore = true
+				return false
+			}
+			if index >= from {
+				data.Entries = append(data.Entries, e)
+			}
+			index++
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !next {
+			break
+		}
+	}
+	return data, nil
+}
+
+// 分页查询 daemon 或 crontab 缓存的日志，按时间倒序返回
+func handleLogBuffer(filter *logFilter, from int, size int) interface{} {
+	var data logPage
+	data.Entries = make([]*log.Entry, 0, size)
+
+	entries := log.Buffer(filter.stype, filter.source)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !filter.match(entries[i]) {
+			continue
+		}
+		if data.Total >= from && data.Total < from+size {
+			data.Entries = append(data.Entries, entries[i])
+		}
+		data.Total++
+	}
+
+	return data
+}
+
+// 通过 SSE（Server-Sent Events）实时推送日志，buffered 为 true 时先推送 daemon 或 crontab 缓存的日志
+func handleLogTail(w http.ResponseWriter, r *http.Request, filter *logFilter, buffered bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		Error(w, errors.New("failed to get an http flusher"))
+		return
+	}
+
+	entries, cancel := log.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(e *log.Entry) {
+		b, _ := json.Marshal(e)
+		w.Write([]byte("data: "))
+		w.Write(b)
+		w.Write([]byte("\n\n"))
+	}
+
+	// 先订阅再读取缓存，订阅之后写入的日志可能同时出现在两者中，这里按指针去重
+	sent := make(map[*log.Entry]struct{})
+	if buffered {
+		for _, e := range log.Buffer(filter.stype, filter.source) {
+			if filter.match(e) {
+				send(e)
+				sent[e] = struct{}{}
+			}
+		}
+		flusher.Flush()
+	}
+
+	for {
+		select {
+		case <-r.Context().Done(): // 客户端断开连接
+			return
+		case e := <-entries:
+			if _, ok := sent[e]; ok || !filter.match(e) {
+				continue
+			}
+			send(e)
+			flusher.Flush()
+		}
+	}
+}
+
+// 从文件末尾开始按块读取，逆序遍历文件中的日志，fn 返回 false 时停止遍历，返回是否继续遍历下一个文件
+func scanLogEntriesReverse(file string, fn func(e *log.Entry) bool) (bool, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer fd.Close()
+	info, err := fd.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	emit := func(line []byte) bool {
+		e := &log.Entry{}
+		if len(bytes.TrimSpace(line)) == 0 || json.Unmarshal(line, e) != nil { // 忽略无法解析的行，例如旧版本的纯文本日志、正在写入的行
+			return true
+		}
+		return fn(e)
+	}
+
+	chunk := make([]byte, 64*1024)
+	rest := make([]byte, 0) // 已读取但尚未遍历的内容，即块中第一个换行符之前的部分
+	for pos := info.Size(); pos > 0; {
+		n := min(int64(len(chunk)), pos)
+		pos -= n
+		if _, err := fd.ReadAt(chunk[:n], pos); err != nil && err != io.EOF {
+			return false, err
+		}
+		rest = append(append(make([]byte, 0, int(n)+len(rest)), chunk[:n]...), rest...)
+		for {
+			i := bytes.LastIndexByte(rest, '\n')
+			if i < 0 {
+				break
+			}
+			if !emit(rest[i+1:]) {
+				return false, nil
+			}
+			rest = rest[:i]
+		}
+	}
+	return emit(rest), nil
+}
@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cube/internal/log"
+)
+
+func TestScanLogEntriesReverse(t *testing.T) {
+	long := strings.Repeat("x", 100*1024) // 超过读取的块大小
+	lines := make([]string, 0)
+	expected := make([]string, 0)
+	for i := 0; i < 5000; i++ {
+		msg := fmt.Sprintf("message %d", i)
+		if i%1000 == 0 {
+			msg += long
+		}
+		lines = append(lines, `{"level":"info","msg":"`+msg+`"}`)
+		expected = append([]string{msg}, expected...)
+		if i%700 == 0 {
+			lines = append(lines, "plain text", "")
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "cube.log")
+	for _, content := range []string{strings.Join(lines, "\n") + "\n", strings.Join(lines, "\n"), strings.Join(lines, "\r\n")} {
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		messages := make([]string, 0)
+		next, err := scanLogEntriesReverse(file, func(e *log.Entry) bool {
+			messages = append(messages, e.Message)
+			return true
+		})
+		if err != nil || !next || !reflect.DeepEqual(messages, expected) {
+			t.Fatalf("scanLogEntriesReverse returned %d entries, %v, %v", len(messages), next, err)
+		}
+
+		// 返回 false 时停止遍历
+		count := 0
+		next, err = scanLogEntriesReverse(file, func(e *log.Entry) bool {
+			count++
+			return count < 10
+		})
+		if err != nil || next || count != 10 {
+			t.Fatalf("scanLogEntriesReverse did not stop: %d, %v, %v", count, next, err)
+		}
+	}
+
+	if next, err := scanLogEntriesReverse(filepath.Join(t.TempDir(), "missing.log"), nil); err != nil || !next {
+		t.Fatalf("scanLogEntriesReverse of a missing file: %v, %v", next, err)
+	}
+}
@@ -0,0 +1,18 @@
+package handler
+
+import (
+	"net/http"
+
+	"cube/internal/metrics"
+)
+
+// HandleMetrics 以 Prometheus 文本格式输出监控指标
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.Write(w)
+}
@@ -0,0 +1,272 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cube/internal"
+	"cube/internal/cache"
+	"cube/internal/util"
+)
+
+// PackageMaxSize 上传的压缩包的最大字节数
+const PackageMaxSize = 32 << 20
+
+func HandlePackage(w http.ResponseWriter, r *http.Request) {
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodPost:
+		data, err = handlePackagePost(w, r)
+	case http.MethodDelete:
+		err = handlePackageDelete(r)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+// 导入 npm 包：读取 .tgz（npm pack 生成）或 .zip 压缩包中的 package.json，将其中的 .js、.cjs 和 .json 文件保存为 node_modules/<包名>/... 模块
+// 并根据 main 和 exports 生成入口模块，如 require("dayjs") 对应 node_modules/dayjs/dayjs.min，已导入的同名包将被替换
+func handlePackagePost(w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, PackageMaxSize) // 同时限制 multipart 表单的大小，FormFile 读取的是 r.Body
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		body = file
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := util.ReadArchive(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// 以路径最短的 package.json 所在的目录作为包的根目录，如 npm pack 生成的 package/package.json
+	root := ""
+	for name := range files {
+		if path.Base(name) != "package.json" || strings.Contains("/"+name, "/node_modules/") {
+			continue
+		}
+		if dir := path.Dir(name); root == "" || len(dir) < len(root) {
+			root = dir
+		}
+	}
+	if root == "" {
+		return nil, errors.New("package.json not found")
+	}
+
+	var pkg struct {
+		Name    string      `json:"name"`
+		Version string      `json:"version"`
+		Main    string      `json:"main"`
+		Exports interface{} `json:"exports"`
+	}
+	if err := json.Unmarshal(files[path.Join(root, "package.json")], &pkg); err != nil {
+		return nil, errors.New("invalid package.json: " + err.Error())
+	}
+	if ok, _ := regexp.MatchString(`^(@[\w.-]+/)?[\w.-]+$`, pkg.Name); !ok {
+		return nil, errors.New("invalid package name: " + pkg.Name)
+	}
+	prefix := "node_modules/" + pkg.Name
+
+	type module struct {
+		lang    string
+		content string
+	}
+	modules := make(map[string]module)
+	for name, content := range files {
+		rel := name
+		if root != "." {
+			if !strings.HasPrefix(name, root+"/") {
+				continue
+			}
+			rel = name[len(root)+1:]
+		}
+		if strings.Contains("/"+rel, "/node_modules/") {
+			continue
+		}
+		lang := map[string]string{".js": "javascript", ".cjs": "javascript", ".json": "json"}[path.Ext(rel)]
+		if lang == "" { // 忽略 ES 模块（.mjs）、类型声明和其他资源文件
+			continue
+		}
+		// 模块名称不包含扩展名，同名时优先使用 .js 文件，与 require 省略扩展名时的解析顺序一致
+		key := prefix + "/" + strings.TrimSuffix(rel, path.Ext(rel))
+		if m, ok := modules[key]; ok && m.lang == "javascript" {
+			continue
+		}
+		modules[key] = module{lang, string(content)}
+	}
+
+	// 生成入口模块，将 require 的包名或子路径转发到 exports 或 main 指定的文件
+	entry := func(target string) (string, bool) {
+		target = path.Clean(strings.TrimPrefix(target, "./"))
+		target = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(target, ".js"), ".cjs"), ".json")
+		_, ok := modules[prefix+"/"+target]
+		if !ok {
+			_, ok = modules[prefix+"/"+target+"/index"]
+		}
+		return target, ok
+	}
+	exports := packageExports(pkg.Exports)
+	if _, ok := exports["."]; !ok {
+		exports["."] = pkg.Main
+		if pkg.Main == "" {
+			exports["."] = "index.js"
+		}
+	}
+	main := ""
+	for subpath, target := range exports {
+		if strings.Contains(subpath, "*") || subpath == "./package.json" {
+			continue
+		}
+		target, ok := entry(target)
+		if !ok {
+			continue
+		}
+		name := prefix
+		if subpath != "." {
+			name = prefix + "/" + strings.TrimSuffix(strings.TrimSuffix(path.Clean(strings.TrimPrefix(subpath, "./")), ".js"), ".cjs")
+		} else {
+			main = target
+		}
+		if name != prefix+"/"+target {
+			modules[name] = module{"javascript", "module.exports = require(\"" + pkg.Name + "/" + target + "\");"}
+		}
+	}
+	if len(modules) == 0 {
+		return nil, errors.New("no modules found in package")
+	}
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// 校验模块名称及语法，以免导入后无法在 IDE 中编辑或加载
+	for _, name := range names {
+		if err := internal.CheckSourceName(name, "module"); err != nil {
+			return nil, errors.New("unsupported file name " + name + " in package, " + err.Error())
+		}
+		if err := internal.CheckSource(name, "module", modules[name].lang, modules[name].content, "", ""); err != nil {
+			return nil, errors.New("invalid file " + name + " in package, " + err.Error())
+		}
+	}
+
+	// 替换已导入的同名包
+	tx, err := internal.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("delete from source where type = 'module' and (name = ? or substr(name, 1, ?) = ?)", prefix, len(prefix)+1, prefix+"/"); err != nil {
+		return nil, err
+	}
+	stmt, err := tx.Prepare("insert into source (name, type, lang, content, active, tag) values (?, 'module', ?, ?, true, 'npm')")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+	for _, name := range names {
+		if _, err := stmt.Exec(name, modules[name].lang, modules[name].content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := internal.UpdateDependencies(name, "module"); err != nil {
+			return nil, err
+		}
+	}
+
+	cache.Module.Clear()
+	audit(r, "package", "node_modules/"+pkg.Name, "module", "version: "+pkg.Version)
+
+	return map[string]interface{}{
+		"name":    pkg.Name,
+		"version": pkg.Version,
+		"main":    main,
+		"modules": len(names),
+	}, nil
+}
+
+// 删除已导入的 npm 包
+func handlePackageDelete(r *http.Request) error {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	name := p.Get("name")
+	if name == "" {
+		return errors.New("name is required")
+	}
+	prefix := "node_modules/" + name
+	if _, err := internal.Db.Exec("delete from source where type = 'module' and (name = ? or substr(name, 1, ?) = ?)", prefix, len(prefix)+1, prefix+"/"); err != nil {
+		return err
+	}
+	cache.Module.Clear()
+	audit(r, "delete", prefix, "module", "package")
+	return nil
+}
+
+// 解析 package.json 中的 exports，返回子路径到文件的映射，条件导出按 require、node、default 的顺序选择，忽略仅适用于 ES 模块的 import
+func packageExports(exports interface{}) map[string]string {
+	var target func(v interface{}) string
+	target = func(v interface{}) string {
+		switch t := v.(type) {
+		case string:
+			return t
+		case []interface{}:
+			for _, i := range t {
+				if s := target(i); s != "" {
+					return s
+				}
+			}
+		case map[string]interface{}:
+			for _, c := range []string{"require", "node", "default"} {
+				if s := target(t[c]); s != "" {
+					return s
+				}
+			}
+		}
+		return ""
+	}
+
+	result := make(map[string]string)
+	if m, ok := exports.(map[string]interface{}); ok {
+		subpaths := false
+		for k, v := range m {
+			if strings.HasPrefix(k, ".") {
+				subpaths = true
+				if s := target(v); s != "" {
+					result[k] = s
+				}
+			}
+		}
+		if subpaths {
+			return result
+		}
+	}
+	if s := target(exports); s != "" {
+		result["."] = s
+	}
+	return result
+}
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"cube/internal"
+	"cube/internal/cache"
+	"cube/internal/model"
+	"cube/internal/util"
+)
+
+func HandleRemote(w http.ResponseWriter, r *http.Request) {
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		data, err = handleRemoteGet(r)
+	case http.MethodPost:
+		data, err = handleRemotePost(r)
+	case http.MethodPut:
+		data, err = handleRemotePut(r)
+	case http.MethodDelete:
+		err = handleRemoteDelete(r)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+// 查询已保存的远程模块，指定 url 时返回其内容
+func handleRemoteGet(r *http.Request) (interface{}, error) {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	url := p.Get("url")
+
+	rows, err := internal.Db.Query("select url, case when ? != '' then content else '' end, sha256, pinned, length(cast(content as blob)), fetched_date from remote_module where ? = '' or url = ? order by url", url, url, url)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	modules := make([]model.RemoteModule, 0)
+	for rows.Next() {
+		var m model.RemoteModule
+		if err := rows.Scan(&m.Url, &m.Content, &m.Sha256, &m.Pinned, &m.Size, &m.FetchedDate); err != nil {
+			return nil, err
+		}
+		modules = append(modules, m)
+	}
+	return modules, rows.Err()
+}
+
+// 下载或重新下载远程模块并保存，指定 sha256 时校验内容的摘要，已锁定的模块须指定新的摘要才能更新
+// 不指定 url 时，重新下载所有未锁定的模块，返回每个模块的结果
+func handleRemotePost(r *http.Request) (interface{}, error) {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	url := p.Get("url")
+
+	if url != "" {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return nil, errors.New("url must start with http:// or https://")
+		}
+		content, err := internal.FetchRemoteModule(url)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := internal.SaveRemoteModule(url, content, p.Get("sha256"))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"url":    url,
+			"sha256": hash,
+		}, nil
+	}
+
+	rows, err := internal.Db.Query("select url from remote_module where pinned = false order by url")
+	if err != nil {
+		return nil, err
+	}
+	var urls []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	rows.Close()
+
+	type result struct {
+		Url    string `json:"url"`
+		Sha256 string `json:"sha256,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}
+	results := make([]result, 0, len(urls))
+	for _, u := range urls {
+		content, err := internal.FetchRemoteModule(u)
+		if err == nil {
+			var hash string
+			if hash, err = internal.SaveRemoteModule(u, content, ""); err == nil {
+				results = append(results, result{Url: u, Sha256: hash})
+				continue
+			}
+		}
+		results = append(results, result{Url: u, Error: err.Error()}) // 下载失败时保留原有的内容
+	}
+	return results, nil
+}
+
+// 锁定或解锁远程模块；指定 content 时直接保存该内容，用于在无法访问网络的环境中导入远程模块
+func handleRemotePut(r *http.Request) (interface{}, error) {
+	var input struct {
+		Url     string  `json:"url"`
+		Content *string `json:"content"`
+		Sha256  string  `json:"sha256"`
+		Pinned  *bool   `json:"pinned"`
+	}
+	if err := util.UnmarshalWithIoReader(r.Body, &input); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(input.Url, "http://") && !strings.HasPrefix(input.Url, "https://") {
+		return nil, errors.New("url must start with http:// or https://")
+	}
+
+	var hash string
+	if input.Content != nil {
+		var err error
+		if hash, err = internal.SaveRemoteModule(input.Url, *input.Content, input.Sha256); err != nil {
+			return nil, err
+		}
+	}
+	if input.Pinned != nil {
+		res, err := internal.Db.Exec("update remote_module set pinned = ? where url = ?", *input.Pinned, input.Url)
+		if err != nil {
+			return nil, err
+		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			return nil, errors.New("remote module does not existed")
+		}
+	}
+
+	if hash == "" {
+		if err := internal.Db.QueryRow("select sha256 from remote_module where url = ?", input.Url).Scan(&hash); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{
+		"url":    input.Url,
+		"sha256": hash,
+	}, nil
+}
+
+// 删除远程模块，下次加载时将重新下载
+func handleRemoteDelete(r *http.Request) error {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	url := p.Get("url")
+	if url == "" {
+		return errors.New("url is required")
+	}
+	if _, err := internal.Db.Exec("delete from remote_module where url = ?", url); err != nil {
+		return err
+	}
+	cache.Module.Remove(url)
+	return nil
+}
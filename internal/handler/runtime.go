@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"cube/internal"
+	"cube/internal/cache"
+	"cube/internal/util"
+)
+
+// HandleHealthz 存活探针，进程能够响应请求即视为存活
+func HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+// HandleReadyz 就绪探针，在服务初始化完成之前返回 503
+func HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !internal.Ready.Load() {
+		Error(w, http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+func HandleRuntime(w http.ResponseWriter, r *http.Request) {
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		data = handleRuntimeGet()
+	case http.MethodPost:
+		err = handleRuntimePost(r)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+// 查询 worker、daemon 和定时任务的运行状态
+func handleRuntimeGet() interface{} {
+	type daemon struct {
+		Name string `json:"name"`
+		internal.WorkerStatus
+	}
+	type crontab struct {
+		Name string     `json:"name"`
+		Id   int        `json:"id"`
+		Next *time.Time `json:"next,omitempty"`
+		Prev *time.Time `json:"prev,omitempty"`
+	}
+	var data struct {
+		Workers  []internal.WorkerStatus `json:"workers"`
+		Daemons  []daemon                `json:"daemons"`
+		Crontabs []crontab               `json:"crontabs"`
+	}
+
+	data.Workers = make([]internal.WorkerStatus, 0, len(internal.WorkerPool.Workers))
+	for _, worker := range internal.WorkerPool.Workers {
+		data.Workers = append(data.Workers, worker.Status())
+	}
+
+	data.Daemons = make([]daemon, 0)
+	for _, name := range cache.Daemon.Names() {
+		if worker, ok := cache.Daemon.Get(name); ok {
+			data.Daemons = append(data.Daemons, daemon{name, internal.WorkerPool.Workers[worker.Id()].Status()})
+		}
+	}
+
+	data.Crontabs = make([]crontab, 0)
+	if internal.Crontab != nil {
+		names := cache.Crontab.Names()
+		for _, e := range internal.Crontab.Entries() {
+			c := crontab{Name: names[e.ID], Id: int(e.ID)}
+			if !e.Next.IsZero() {
+				c.Next = &e.Next
+			}
+			if !e.Prev.IsZero() {
+				c.Prev = &e.Prev
+			}
+			data.Crontabs = append(data.Crontabs, c)
+		}
+		sort.Slice(data.Crontabs, func(i, j int) bool {
+			return data.Crontabs[i].Name < data.Crontabs[j].Name
+		})
+	}
+
+	return data
+}
+
+// 中断指定 worker 正在执行的脚本
+func handleRuntimePost(r *http.Request) error {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	id, err := strconv.Atoi(p.Get("interrupt"))
+	if err != nil || id < 0 || id >= len(internal.WorkerPool.Workers) {
+		return errors.New("interrupt must be a valid worker id")
+	}
+
+	worker := internal.WorkerPool.Workers[id]
+	if !worker.Status().Busy {
+		return errors.New("worker is idle")
+	}
+
+	reason := p.Get("reason")
+	if reason == "" {
+		reason = "interrupted by administrator"
+	}
+	worker.Interrupt(reason)
+	return nil
+}
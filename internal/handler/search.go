@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"cube/internal"
+	"cube/internal/model"
+	"cube/internal/util"
+)
+
+func HandleSearch(w http.ResponseWriter, r *http.Request) {
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		data, err = handleSearchGet(r)
+	case http.MethodPost:
+		data, err = handleSearchReplace(r)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+// 在源码内容中搜索，返回匹配的源码及匹配的行，按源码分页
+func handleSearchGet(r *http.Request) (interface{}, error) {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	query, isRegexp := p.Get("q"), p.Has("regexp")
+	from, size := p.GetIntOrDefault("from", 0), p.GetIntOrDefault("size", 10)
+
+	pattern, err := internal.SearchPattern(query, isRegexp, p.Has("case"))
+	if err != nil {
+		return nil, err
+	}
+	literal := query
+	if isRegexp {
+		literal = ""
+	}
+	sources, err := internal.FindSources(pattern, literal, p.GetOrDefault("name", "%"), p.GetOrDefault("type", "%"))
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Results []model.SearchResult `json:"results"`
+		Total   int                  `json:"total"`
+	}
+	data.Results, data.Total = make([]model.SearchResult, 0, size), len(sources)
+	for i := from; i >= 0 && i < len(sources) && i < from+size; i++ {
+		s := sources[i]
+		lines, count := internal.SearchLines(s.Content, pattern)
+		data.Results = append(data.Results, model.SearchResult{Id: s.Id, Name: s.Name, Type: s.Type, Lang: s.Lang, Active: s.Active, Count: count, Lines: lines})
+	}
+	return data, nil
+}
+
+// 在匹配的源码中替换内容，dry_run 为 true 时仅返回每个源码的差异
+// 替换后的源码将重新编译并校验语法，在同一个事务中写入，任一源码失败或在此期间被修改时均不替换，草稿不受影响
+func handleSearchReplace(r *http.Request) (interface{}, error) {
+	var params struct {
+		Query       string         `json:"query"`
+		Replacement string         `json:"replacement"`
+		Regexp      bool           `json:"regexp"` // 为 true 时 replacement 中可使用 $1、${name} 引用分组
+		Case        bool           `json:"case"`
+		Name        string         `json:"name"`
+		Type        string         `json:"type"`
+		Sources     []model.Source `json:"sources"` // 仅替换指定的源码，为空时替换所有匹配的源码
+		DryRun      bool           `json:"dry_run"`
+	}
+	if err := util.UnmarshalWithIoReader(r.Body, &params); err != nil {
+		return nil, err
+	}
+	pattern, err := internal.SearchPattern(params.Query, params.Regexp, params.Case)
+	if err != nil {
+		return nil, err
+	}
+	literal := params.Query
+	if params.Regexp {
+		literal = ""
+	}
+	if params.Name == "" {
+		params.Name = "%"
+	}
+	if params.Type == "" {
+		params.Type = "%"
+	}
+	sources, err := internal.FindSources(pattern, literal, params.Name, params.Type)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[[2]string]bool)
+	for _, s := range params.Sources {
+		selected[[2]string{s.Name, s.Type}] = true
+	}
+
+	type replacement struct {
+		source    model.Source
+		content   string
+		compiled  string
+		sourceMap string
+		count     int
+	}
+	replacements := make([]replacement, 0, len(sources))
+	for _, s := range sources {
+		if len(selected) > 0 && !selected[[2]string{s.Name, s.Type}] {
+			continue
+		}
+		var content string
+		if params.Regexp {
+			content = pattern.ReplaceAllString(s.Content, params.Replacement)
+		} else {
+			content = pattern.ReplaceAllLiteralString(s.Content, params.Replacement)
+		}
+		if content != s.Content {
+			replacements = append(replacements, replacement{source: s, content: content, count: len(pattern.FindAllStringIndex(s.Content, -1))})
+		}
+	}
+
+	// 预览
+	if params.DryRun {
+		previews := make([]map[string]interface{}, 0, len(replacements))
+		for _, rp := range replacements {
+			previews = append(previews, map[string]interface{}{
+				"name":  rp.source.Name,
+				"type":  rp.source.Type,
+				"count": rp.count,
+				"diff":  util.UnifiedDiff(rp.source.Content, rp.content, rp.source.Name, rp.source.Name, 3),
+			})
+		}
+		return previews, nil
+	}
+	if len(replacements) == 0 {
+		return nil, errors.New("nothing was replaced")
+	}
+	if err := requireRole(r, "publisher"); err != nil {
+		return nil, err
+	}
+
+	// 在写入之前编译全部源码，存在语法错误时不替换任何源码
+	for i, rp := range replacements {
+		compiled, sourceMap, err := internal.CompileSource(rp.source.Name, rp.source.Type, rp.source.Lang, rp.content, "")
+		if err != nil {
+			return nil, err
+		}
+		replacements[i].compiled, replacements[i].sourceMap = compiled, sourceMap
+	}
+
+	tx, err := internal.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, rp := range replacements {
+		res, err := tx.Exec("update source set content = ?, compiled = ?, source_map = ?, last_modified_date = datetime('now', 'localtime') where name = ? and type = ? and content = ?", rp.content, rp.compiled, rp.sourceMap, rp.source.Name, rp.source.Type, rp.source.Content)
+		if err != nil {
+			return nil, err
+		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			return nil, errors.New(rp.source.Type + " " + rp.source.Name + " has been modified, please search again")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// 提交后依次刷新缓存
+	replaced := make([]map[string]interface{}, 0, len(replacements))
+	for _, rp := range replacements {
+		result, err := refreshSource(r, rp.source.Name, rp.source.Type, nil, "replace", params.Query+" -> "+params.Replacement)
+		if err != nil {
+			return nil, err
+		}
+		result["count"] = rp.count
+		replaced = append(replaced, result)
+	}
+	return replaced, nil
+}
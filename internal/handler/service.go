@@ -8,30 +8,45 @@ import (
 	"cube/internal"
 	"cube/internal/cache"
 	"cube/internal/log"
+	"cube/internal/metrics"
+	"cube/internal/model"
 	"cube/internal/util"
 )
 
 func HandleService(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/service/")
+	serveService(w, r, strings.TrimPrefix(r.URL.Path, "/service/"), false)
+}
+
+// HandlePreviewService 与 HandleService 相同，但 controller 及其依赖的模块优先使用未发布的草稿，如 /preview/service/foo 预览 /service/foo
+func HandlePreviewService(w http.ResponseWriter, r *http.Request) {
+	serveService(w, r, strings.TrimPrefix(r.URL.Path, "/preview/service/"), true)
+}
 
+func serveService(w http.ResponseWriter, r *http.Request, path string, preview bool) {
 	// 查询 controller
+	var source *model.Source
 	name, vars := cache.Route.Get(path)
-	if name == "" {
+	if name != "" {
+		source = cache.Controller.Get(name)
+	}
+	if source == nil && preview { // 未启用的 controller 同样可以预览
+		source, vars = internal.PreviewController(path)
+	}
+	if source == nil {
 		Error(w, http.StatusNotFound)
 		return
 	}
 
-	source := cache.Controller.Get(name)
 	if source.Method != "" && source.Method != r.Method { // 校验请求方法
 		Error(w, http.StatusMethodNotAllowed)
 		return
 	}
 
+	setAccessEntry(r, source.Name, -1)
+
 	// 获取 vm 实例
-	var worker *internal.Worker
-	select {
-	case worker = <-internal.WorkerPool.Channels:
-	default:
+	worker := internal.TryAcquireWorker()
+	if worker == nil {
 		Error(w, http.StatusServiceUnavailable) // 如果无可用实例，则返回 503
 		return
 	}
@@ -43,6 +58,10 @@ func HandleService(w http.ResponseWriter, r *http.Request) {
 		internal.WorkerPool.Channels <- worker // 归还实例
 	}()
 
+	setAccessEntry(r, source.Name, worker.Id())
+	worker.SetRequestId(r.Header.Get("X-Request-Id")) // 请求 ID 将自动附加到 console 日志以及 fetch、http 模块的请求头中
+	worker.SetPreview(preview)
+
 	// 允许最大执行的时间为 60 秒
 	timer := time.AfterFunc(60*time.Second, func() {
 		worker.Interrupt("service executed timeout")
@@ -63,6 +82,7 @@ func HandleService(w http.ResponseWriter, r *http.Request) {
 	ctx := internal.NewServiceContext(r, w, timer, &vars)
 
 	// 执行
+	start := time.Now()
 	value, err := worker.Run(
 		worker.Runtime().ToValue("./controller/"+source.Name),
 		worker.Runtime().ToValue(ctx),
@@ -71,9 +91,18 @@ func HandleService(w http.ResponseWriter, r *http.Request) {
 	// 标记脚本执行完成
 	completed = true
 
+	// 记录监控指标，预览的请求不计入
+	if !preview {
+		metrics.ControllerRequests.Inc(source.Name)
+		metrics.ControllerDuration.Observe(time.Since(start).Seconds(), source.Name)
+		if err != nil {
+			metrics.ControllerErrors.Inc(source.Name)
+		}
+	}
+
 	if internal.Returnless(ctx) { // 如果是 WebSocket 或 chunk 响应，不需要封装响应
 		if err != nil {
-			log.Error(worker.Id(), err)
+			log.Error(worker.LogFields(), err)
 		}
 		return
 	}
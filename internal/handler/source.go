@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"math"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -12,10 +13,12 @@ import (
 
 	"cube/internal"
 	"cube/internal/cache"
+	"cube/internal/log"
 	"cube/internal/model"
 	"cube/internal/util"
 
 	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
 )
 
 func HandleSource(w http.ResponseWriter, r *http.Request) {
@@ -26,13 +29,20 @@ func HandleSource(w http.ResponseWriter, r *http.Request) {
 	)
 	switch r.Method {
 	case http.MethodPost:
-		if _, bulk := r.URL.Query()["bulk"]; !bulk {
-			err = handleSourcePost(r)
-		} else {
+		query := r.URL.Query()
+		if _, publish := query["publish"]; publish {
+			data, err = handleSourcePublish(r)
+		} else if _, bulk := query["bulk"]; bulk {
 			err = handleSourceBulkPost(r)
+		} else {
+			err = handleSourcePost(r)
 		}
 	case http.MethodDelete:
-		err = handleSourceDelete(r)
+		if _, draft := r.URL.Query()["draft"]; !draft {
+			err = handleSourceDelete(r)
+		} else {
+			err = handleSourceDraftDelete(r)
+		}
 	case http.MethodPut:
 		data, err = handleSourcePut(r)
 	case http.MethodGet:
@@ -61,36 +71,43 @@ func handleSourcePost(r *http.Request) error {
 	}
 
 	// 校验类型
-	if ok, _ := regexp.MatchString("^(module|controller|daemon|crontab|template|resource)$", source.Type); !ok {
-		return errors.New("type must be module, controller, daemon, crontab, template or resource")
+	if err := internal.CheckSourceType(source.Type); err != nil {
+		return err
+	}
+	// 校验权限
+	if err := requireSourceWrite(r, source.Type); err != nil {
+		return err
 	}
 	// 校验名称
-	if source.Type == "module" {
-		if ok, _ := regexp.MatchString("^(node_modules/)?\\w{2,32}$", source.Name); !ok {
-			return errors.New("name is required, it must be a string that matches /(node_modules/)?[A-Za-z0-9_]{2,32}/")
-		}
-	} else {
-		if ok, _ := regexp.MatchString("^\\w{2,32}$", source.Name); !ok {
-			return errors.New("name is required, it must be a string that matches /[A-Za-z0-9_]{2,32}/")
-		}
+	if err := internal.CheckSourceName(source.Name, source.Type); err != nil {
+		return err
 	}
 	// 校验 active 必须为 false，不支持在创建过程中直接激活
 	if source.Active {
 		return errors.New("active must be false")
 	}
 	// 校验 url 不能重复
-	if source.Type == "controller" || source.Type == "resource" {
-		var count int
-		if err := internal.Db.QueryRow("select count(1) from source where type = ? and url = ? and name != ?", source.Type, source.Url, source.Name).Scan(&count); err != nil {
+	if err := internal.CheckSourceUrl(source.Name, source.Type, source.Url, false); err != nil {
+		return err
+	}
+	// 校验 cron 表达式和执行配置
+	if source.Type == "crontab" {
+		if _, err := util.ParseCron(source.Cron); err != nil {
 			return err
 		}
-		if count > 0 {
-			return errors.New("url already exists")
+		if source.Overlap == "" {
+			source.Overlap = "allow"
+		}
+		if err := internal.CheckCrontabOptions(source.Timeout, source.Overlap, source.Retries); err != nil {
+			return err
 		}
 	}
-	// 校验 cron 表达式
-	if source.Type == "crontab" {
-		if _, err := util.ParseCron(source.Cron); err != nil {
+	// 校验 daemon 的重启策略
+	if source.Type == "daemon" {
+		if source.Restart == "" {
+			source.Restart = "never"
+		}
+		if err := internal.CheckDaemonRestart(source.Restart); err != nil {
 			return err
 		}
 	}
@@ -101,16 +118,30 @@ func handleSourcePost(r *http.Request) error {
 			return errors.New("source already exists")
 		}
 	}
+	// 编译
+	{
+		var err error
+		if source.Compiled, source.SourceMap, err = internal.CompileSource(source.Name, source.Type, source.Lang, source.Content, source.Compiled); err != nil {
+			return err
+		}
+	}
 
 	// 新增
-	if _, err := internal.Db.Exec("insert into source (name, type, lang, content, compiled, active, method, url, cron, tag, last_modified_date) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, datetime('now', 'localtime'))", source.Name, source.Type, source.Lang, source.Content, source.Compiled, source.Active, source.Method, source.Url, source.Cron, source.Tag); err != nil {
+	if _, err := internal.Db.Exec("insert into source (name, type, lang, content, compiled, source_map, active, method, url, cron, timeout, overlap, retries, restart, tag, last_modified_date) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, datetime('now', 'localtime'))", source.Name, source.Type, source.Lang, source.Content, source.Compiled, source.SourceMap, source.Active, source.Method, source.Url, source.Cron, source.Timeout, source.Overlap, source.Retries, source.Restart, source.Tag); err != nil {
 		return err
 	}
 
-	return nil
+	audit(r, "create", source.Name, source.Type, "")
+
+	// 解析依赖关系
+	return internal.UpdateDependencies(source.Name, source.Type)
 }
 
 func handleSourceBulkPost(r *http.Request) error {
+	// 批量导入会覆盖任意源码，须具备 admin 角色
+	if err := requireRole(r, "admin"); err != nil {
+		return err
+	}
 	// 将请求入参转换为 source 对象数组
 	var sources []model.Source
 	if err := util.UnmarshalWithIoReader(r.Body, &sources); err != nil {
@@ -120,8 +151,20 @@ func handleSourceBulkPost(r *http.Request) error {
 		return errors.New("nothing was added or modified")
 	}
 
+	// 在写入之前编译全部源码，存在语法错误时不导入任何源码
+	for i, source := range sources {
+		compiled, sourceMap, err := internal.CompileSource(source.Name, source.Type, source.Lang, source.Content, source.Compiled)
+		if err != nil {
+			return err
+		}
+		sources[i].Compiled = compiled
+		if sourceMap != "" { // 导出文件中的源码已拆分源映射
+			sources[i].SourceMap = sourceMap
+		}
+	}
+
 	// 批量新增或修改
-	stmt, err := internal.Db.Prepare("insert or replace into source (rowid, name, type, lang, content, compiled, active, method, url, cron, tag, last_modified_date) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	stmt, err := internal.Db.Prepare("insert or replace into source (rowid, name, type, lang, content, compiled, source_map, active, method, url, cron, timeout, overlap, retries, restart, tag, last_modified_date) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return err
 	}
@@ -130,9 +173,24 @@ func handleSourceBulkPost(r *http.Request) error {
 		if source.Name == "" || source.Type == "" {
 			continue
 		}
-		if _, err = stmt.Exec(source.Id, source.Name, source.Type, source.Lang, source.Content, source.Compiled, source.Active, source.Method, source.Url, source.Cron, source.Tag, source.LastModifiedDate.String()); err != nil {
+		if source.Overlap == "" { // 兼容旧版本导出的文件
+			source.Overlap = "allow"
+		}
+		if source.Restart == "" {
+			source.Restart = "never"
+		}
+		if _, err = stmt.Exec(source.Id, source.Name, source.Type, source.Lang, source.Content, source.Compiled, source.SourceMap, source.Active, source.Method, source.Url, source.Cron, source.Timeout, source.Overlap, source.Retries, source.Restart, source.Tag, source.LastModifiedDate.String()); err != nil {
 			return err
 		}
+		if err := internal.UpdateDependencies(source.Name, source.Type); err != nil {
+			return err
+		}
+		audit(r, "import", source.Name, source.Type, "")
+	}
+
+	// 按 rowid 替换的其他源码不会触发删除的触发器，需要同步全文索引
+	if err := internal.SyncSourceIndex(); err != nil {
+		return err
 	}
 
 	cache.Route.Init()
@@ -155,13 +213,31 @@ func handleSourceDelete(r *http.Request) error {
 	if stype == "" {
 		return errors.New("type is required")
 	}
+	if err := requireRole(r, "publisher"); err != nil {
+		return err
+	}
+	// 仍被已启用的源码 require 时，须指定 force=true 才能删除
+	if r.Form.Get("force") != "true" {
+		var active bool
+		if internal.Db.QueryRow("select active from source where name = ? and type = ?", name, stype).Scan(&active); active {
+			if err := internal.CheckDependents(name, stype); err != nil {
+				return err
+			}
+		}
+	}
 
 	res, err := internal.Db.Exec("delete from source where name = ? and type = ?", name, stype)
 	if err != nil {
 		return err
 	}
 	if count, _ := res.RowsAffected(); count == 0 {
-		return errors.New("source does not exist")
+		return internal.ErrSourceNotExist
+	}
+	audit(r, "delete", name, stype, "")
+
+	// 删除缓存的日志，以免同名的源码重新创建后显示已删除源码的日志
+	if err := log.RemoveBuffer(stype, name); err != nil { // 源码已删除，不影响删除的结果
+		log.Error(log.Fields{Worker: -1, Source: name, Type: stype}, "failed to remove the buffered logs:", err)
 	}
 
 	// 删除路由
@@ -189,6 +265,34 @@ func handleSourcePut(r *http.Request) (interface{}, error) {
 	if stype == nil {
 		return nil, errors.New("type is required")
 	}
+	// 校验权限：启停以及修改已启用的源码须具备 publisher 角色，保存草稿除外
+	n, _ := name.(string)
+	t, _ := stype.(string)
+	if err := requireSourceWrite(r, t); err != nil {
+		return nil, err
+	}
+	changes := make([]string, 0)
+	for _, c := range []string{"content", "method", "url", "cron", "timeout", "overlap", "retries", "restart", "tag"} {
+		if _, ok := record[c]; ok {
+			changes = append(changes, c)
+		}
+	}
+	var active bool
+	internal.Db.QueryRow("select active from source where name = ? and type = ?", name, stype).Scan(&active)
+	if draft, _ := record["draft"].(bool); !draft {
+		_, hasActive := record["active"]
+		if hasActive || status != nil || (active && len(changes) > 0) {
+			if err := requireRole(r, "publisher"); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// 停用仍被已启用的源码 require 的源码时，须指定 force=true
+	if v, ok := record["active"].(bool); ok && !v && active && r.URL.Query().Get("force") != "true" {
+		if err := internal.CheckDependents(n, t); err != nil {
+			return nil, err
+		}
+	}
 	// 校验 url 不能重复
 	if url != nil && (stype == "controller" || stype == "resource") {
 		var count int
@@ -199,9 +303,35 @@ func handleSourcePut(r *http.Request) (interface{}, error) {
 			return nil, errors.New("url already existed")
 		}
 	}
-	// 校验 cron 表达式
-	if cron != nil && stype == "crontab" {
-		if _, err := util.ParseCron(cron.(string)); err != nil {
+	// 校验 cron 表达式和执行配置
+	if stype == "crontab" {
+		if cron != nil {
+			if _, err := util.ParseCron(cron.(string)); err != nil {
+				return nil, err
+			}
+		}
+		// timeout 和 retries 须为整数，未修改时视为 0
+		for _, key := range []string{"timeout", "retries"} {
+			if v, ok := record[key]; ok {
+				if n, ok := v.(float64); !ok || n != math.Trunc(n) {
+					return nil, errors.New(key + " must be an integer")
+				}
+			}
+		}
+		timeout, _ := record["timeout"].(float64)
+		overlap, _ := record["overlap"].(string)
+		retries, _ := record["retries"].(float64)
+		if _, ok := record["overlap"]; !ok {
+			overlap = "allow" // 未修改时无需校验
+		}
+		if err := internal.CheckCrontabOptions(int(timeout), overlap, int(retries)); err != nil {
+			return nil, err
+		}
+	}
+	// 校验 daemon 的重启策略
+	if restart, ok := record["restart"]; ok && stype == "daemon" {
+		r, _ := restart.(string)
+		if err := internal.CheckDaemonRestart(r); err != nil {
 			return nil, err
 		}
 	}
@@ -212,16 +342,43 @@ func handleSourcePut(r *http.Request) (interface{}, error) {
 			return nil, err
 		}
 		if rdate == "" {
-			return nil, errors.New("source does not existed")
+			return nil, internal.ErrSourceNotExist
 		}
 		if mdate != strings.Replace(strings.Replace(rdate, "T", " ", 1), "Z", "", 1) {
 			return nil, errors.New("source version conflict")
 		}
 	}
 
+	// 编译并校验语法
+	_, hasContent := record["content"]
+	_, hasCompiled := record["compiled"]
+	if hasContent || hasCompiled {
+		var lang string
+		if err := internal.Db.QueryRow("select lang from source where name = ? and type = ?", name, stype).Scan(&lang); err != nil {
+			return nil, internal.ErrSourceNotExist
+		}
+		content, _ := record["content"].(string)
+		if hasCompiled || util.IsTypeScript(lang) {
+			compiled, _ := record["compiled"].(string)
+			compiled, sourceMap, err := internal.CompileSource(n, t, lang, content, compiled)
+			if err != nil {
+				return nil, err
+			}
+			record["compiled"], record["source_map"] = compiled, sourceMap
+		} else if err := internal.CheckSource(n, t, lang, content, "", ""); err != nil {
+			return nil, err
+		}
+	}
+
+	// 保存草稿，已发布的源码保持不变
+	if draft, _ := record["draft"].(bool); draft {
+		audit(r, "draft", n, t, "")
+		return saveSourceDraft(name, stype, record)
+	}
+
 	// 初始化修改字段
 	sets, params := "", []interface{}{}
-	for _, c := range []string{"content", "compiled", "active", "method", "url", "cron", "tag"} {
+	for _, c := range []string{"content", "compiled", "source_map", "active", "method", "url", "cron", "timeout", "overlap", "retries", "restart", "tag"} {
 		if v, ok := record[c]; ok {
 			sets += ", " + c + " = ?"
 			params = append(params, v)
@@ -233,57 +390,130 @@ func handleSourcePut(r *http.Request) (interface{}, error) {
 		return nil, err
 	}
 	if count, _ := res.RowsAffected(); count == 0 {
-		return nil, errors.New("source does not existed")
+		return nil, internal.ErrSourceNotExist
+	}
+
+	// 刷新缓存，修改和启停分别记录审计日志
+	result, err := refreshSource(r, n, t, status, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		audit(r, "edit", n, t, strings.Join(changes, ", "))
+	}
+	if v, ok := record["active"].(bool); ok && v != active {
+		audit(r, map[bool]string{true: "activate", false: "deactivate"}[v], n, t, "")
+	}
+	if status == "true" || status == "false" {
+		audit(r, map[interface{}]string{"true": "start", "false": "stop"}[status], n, t, "")
+	}
+	return result, nil
+}
+
+// 保存草稿，草稿仅包含源码内容，可通过预览路由或 EVAL 试用，发布后才会生效
+func saveSourceDraft(name interface{}, stype interface{}, record map[string]interface{}) (interface{}, error) {
+	content, ok := record["content"].(string)
+	if !ok {
+		return nil, errors.New("content is required")
+	}
+	compiled, _ := record["compiled"].(string)
+	sourceMap, _ := record["source_map"].(string)
+
+	res, err := internal.Db.Exec("update source set draft = true, draft_content = ?, draft_compiled = ?, draft_source_map = ?, draft_modified_date = datetime('now', 'localtime'), draft_base_date = last_modified_date where name = ? and type = ?", content, compiled, sourceMap, name, stype)
+	if err != nil {
+		return nil, err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return nil, internal.ErrSourceNotExist
 	}
 
-	// 查询更新后的记录
 	var source model.Source
-	if err := internal.Db.QueryRow("select name, type, lang, active, method, url, cron, tag, last_modified_date from source where name = ? and type = ?", name, stype).Scan(&source.Name, &source.Type, &source.Lang, &source.Active, &source.Method, &source.Url, &source.Cron, &source.Tag, &source.LastModifiedDate); err != nil {
+	if err := internal.Db.QueryRow("select name, type, last_modified_date, draft_modified_date from source where name = ? and type = ?", name, stype).Scan(&source.Name, &source.Type, &source.LastModifiedDate, &source.DraftModifiedDate); err != nil {
 		return nil, err
 	}
+	if id := internal.SourceModuleId(source.Name, source.Type); id != "" {
+		cache.Preview.Remove(id) // 删除预览缓存
+	}
 
-	switch source.Type {
-	case "module":
-		if strings.HasPrefix(source.Name, "node_modules/") {
-			cache.Module.Remove(source.Name[13:]) // 删除缓存
-		} else {
-			cache.Module.Remove("./" + source.Name)
+	return map[string]interface{}{
+		"last_modified_date":  source.LastModifiedDate, // 已发布的版本号不变
+		"draft_modified_date": source.DraftModifiedDate,
+	}, nil
+}
+
+// 在同一个事务中发布多个源码的草稿（如一个 controller 及其修改过的模块），任一源码没有草稿时均不发布
+func handleSourcePublish(r *http.Request) (interface{}, error) {
+	var sources []model.Source
+	if err := util.UnmarshalWithIoReader(r.Body, &sources); err != nil {
+		return nil, err
+	}
+	if len(sources) == 0 {
+		return nil, errors.New("nothing to publish")
+	}
+	if err := requireRole(r, "publisher"); err != nil {
+		return nil, err
+	}
+
+	tx, err := internal.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, source := range sources {
+		// 已发布的源码在保存草稿后被修改或回滚时不发布，以免被草稿覆盖（旧版本保存的草稿没有记录版本号，不校验）
+		var draft, based bool
+		if err := tx.QueryRow("select draft, draft_base_date is null or draft_base_date = last_modified_date from source where name = ? and type = ?", source.Name, source.Type).Scan(&draft, &based); err != nil || !draft {
+			return nil, errors.New(source.Type + " " + source.Name + " has no draft")
 		}
-	case "controller":
-		if source.Active {
-			// 更新路由
-			cache.Route.Set(source.Name, source.Url) // 更新路由
-		} else {
-			// 删除路由
-			cache.Route.Remove(source.Name)
-		}
-		cache.Controller.Remove(source.Name) // 删除缓存
-		cache.Module.Remove("./controller/" + source.Name)
-	case "crontab":
-		id, ok := cache.Crontab.Get(source.Name)
-		if !ok && source.Active {
-			internal.RunCrontabs(source.Name) // 启动 crontab
-		}
-		if ok && !source.Active {
-			internal.Crontab.Remove(id)
-			cache.Crontab.Remove(source.Name) // 删除缓存
-		}
-		cache.Module.Remove("./crontab/" + source.Name)
-	case "daemon":
-		if source.Active {
-			if _, exists := cache.Daemon.Get(source.Name); !exists && status == "true" {
-				internal.RunDaemons(source.Name) // 启动
-			}
-			if worker, exists := cache.Daemon.Get(source.Name); exists && status == "false" {
-				worker.Interrupt("Daemon stopped") // 停止，停止后会自动清理缓存，见 RunDaemons 方法的 defer 实现
-			}
+		if !based {
+			return nil, errors.New(source.Type + " " + source.Name + " was modified after the draft was saved, save the draft again to publish it")
 		}
-		cache.Module.Remove("./daemon/" + source.Name)
+		if _, err := tx.Exec("update source set content = draft_content, compiled = draft_compiled, source_map = draft_source_map, draft = false, draft_content = '', draft_compiled = '', draft_source_map = '', draft_modified_date = null, draft_base_date = null, last_modified_date = datetime('now', 'localtime') where name = ? and type = ?", source.Name, source.Type); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
 	}
 
-	return map[string]interface{}{
-		"last_modified_date": source.LastModifiedDate,
-	}, nil
+	// 提交后依次刷新缓存
+	published := make([]map[string]interface{}, 0, len(sources))
+	for _, s := range sources {
+		result, err := refreshSource(r, s.Name, s.Type, nil, "publish", "")
+		if err != nil {
+			return nil, err
+		}
+		published = append(published, result)
+	}
+	return published, nil
+}
+
+// 丢弃草稿
+func handleSourceDraftDelete(r *http.Request) error {
+	r.ParseForm()
+	name, stype := r.Form.Get("name"), r.Form.Get("type")
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if stype == "" {
+		return errors.New("type is required")
+	}
+	if err := requireSourceWrite(r, stype); err != nil {
+		return err
+	}
+
+	res, err := internal.Db.Exec("update source set draft = false, draft_content = '', draft_compiled = '', draft_source_map = '', draft_modified_date = null, draft_base_date = null where name = ? and type = ? and draft = true", name, stype)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return errors.New("draft does not exist")
+	}
+	audit(r, "discard", name, stype, "")
+	if id := internal.SourceModuleId(name, stype); id != "" {
+		cache.Preview.Remove(id)
+	}
+	return nil
 }
 
 func handleSourceGet(w http.ResponseWriter, r *http.Request) (interface{}, bool, error) {
@@ -307,6 +537,10 @@ func handleSourceGet(w http.ResponseWriter, r *http.Request) (interface{}, bool,
 		}
 		wheres += ")"
 	}
+	// 仅查询存在草稿的源码
+	if p.Has("draft") {
+		wheres += " and draft = true"
+	}
 	// 构造 ID 过滤查询条件
 	if include != "" {
 		wheres += " and rowid in (" + strings.Repeat(",?", strings.Count(include, ",")+1)[1:] + ")"
@@ -339,13 +573,14 @@ func handleSourceGet(w http.ResponseWriter, r *http.Request) (interface{}, bool,
 	}
 
 	// 分页查询，默认查询所有字段
-	columns := "rowid, name, type, lang, content, compiled, active, method, url, cron, tag, last_modified_date"
-	if p.Has("content") { // 不返回 compiled 字段，用于编辑器查询源码
-		columns = strings.Replace(columns, ", compiled", ", '' compiled", 1)
+	columns := "rowid, name, type, lang, content, compiled, source_map, active, method, url, cron, timeout, overlap, retries, restart, tag, last_modified_date, draft, draft_content, draft_modified_date"
+	if p.Has("content") { // 不返回 compiled、source_map 字段，用于编辑器查询源码
+		columns = strings.Replace(columns, ", compiled, source_map", ", '' compiled, '' source_map", 1)
 	}
-	if p.Has("basic") { // 不返回 content、compiled 字段，用于列表查询
+	if p.Has("basic") { // 不返回 content、compiled、source_map 字段，用于列表查询
 		columns = strings.Replace(columns, ", content", ", '' content", 1)
-		columns = strings.Replace(columns, ", compiled", ", '' compiled", 1)
+		columns = strings.Replace(columns, ", compiled, source_map", ", '' compiled, '' source_map", 1)
+		columns = strings.Replace(columns, ", draft_content", ", '' draft_content", 1)
 	}
 	rows, err := internal.Db.Query("select "+columns+" from source where "+wheres+" order by "+orders+" limit ?, ?", append(params, []interface{}{from, size}...)...)
 	if err != nil {
@@ -354,12 +589,16 @@ func handleSourceGet(w http.ResponseWriter, r *http.Request) (interface{}, bool,
 	defer rows.Close()
 	for rows.Next() {
 		source := model.Source{}
-		if err := rows.Scan(&source.Id, &source.Name, &source.Type, &source.Lang, &source.Content, &source.Compiled, &source.Active, &source.Method, &source.Url, &source.Cron, &source.Tag, &source.LastModifiedDate); err != nil {
+		if err := rows.Scan(&source.Id, &source.Name, &source.Type, &source.Lang, &source.Content, &source.Compiled, &source.SourceMap, &source.Active, &source.Method, &source.Url, &source.Cron, &source.Timeout, &source.Overlap, &source.Retries, &source.Restart, &source.Tag, &source.LastModifiedDate, &source.Draft, &source.DraftContent, &source.DraftModifiedDate); err != nil {
 			continue
 		}
-		if source.Type == "daemon" { // 如果是 daemon，写入状态
-			_, exists := cache.Daemon.Get(source.Name)
-			source.Status = strconv.FormatBool(exists)
+		if source.Type == "daemon" { // 如果是 daemon，写入监管状态
+			state := internal.GetDaemonState(source.Name)
+			source.Status, source.Restarts, source.LastExit = state.Status, state.Restarts, state.LastExit
+			if !state.LastExitTime.IsZero() {
+				t := util.Time(state.LastExitTime)
+				source.LastExitTime = &t
+			}
 		}
 		data.Sources = append(data.Sources, source)
 	}
@@ -385,12 +624,38 @@ func handleSourceEval(w http.ResponseWriter, r *http.Request) {
 		Error(w, err)
 		return
 	}
+	query := r.URL.Query()
+	audit(r, "eval", query.Get("name"), query.Get("type"), script) // IDE 执行时传入所执行的源码
+
+	// 编译，脚本的首行与闭包位于同一行，且源映射的引用位于末尾，以便异常堆栈中的位置能够通过源映射还原
+	code, sourceMap := util.SplitSourceMap("eval", script)
+	if sourceMap != "" {
+		code = code[:strings.LastIndex(code, "//# sourceMappingURL=")]
+	}
+	parsed, err := goja.Parse("eval", strings.Join([]string{
+		"(function () { const console = { __logs__: [], log: function(...args) { this.__logs__.push(['log', new Date(), ...args]) }, }; " + code,
+		";return { logs: console.__logs__, };",
+		"//# sourceMappingURL=eval.js.map",
+		"})",
+	}, "\n"), parser.WithSourceMapLoader(func(p string) ([]byte, error) {
+		if sourceMap == "" {
+			return nil, nil
+		}
+		return []byte(sourceMap), nil
+	}))
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	program, err := goja.CompileAST(parsed, false)
+	if err != nil {
+		Error(w, err)
+		return
+	}
 
 	// 获取 vm 实例
-	var worker *internal.Worker
-	select {
-	case worker = <-internal.WorkerPool.Channels:
-	default:
+	worker := internal.TryAcquireWorker()
+	if worker == nil {
 		Error(w, http.StatusServiceUnavailable)
 		return
 	}
@@ -402,6 +667,10 @@ func handleSourceEval(w http.ResponseWriter, r *http.Request) {
 		internal.WorkerPool.Channels <- worker
 	}()
 
+	// 预览时 require 的模块优先使用未发布的草稿，IDE 中执行的即为草稿
+	_, preview := query["preview"]
+	worker.SetPreview(preview)
+
 	// 允许最大执行的时间为 60 秒
 	timer := time.AfterFunc(60*time.Second, func() {
 		worker.Interrupt("service executed timeout")
@@ -419,17 +688,14 @@ func handleSourceEval(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// 编译
-	entry, _ := worker.Runtime().RunString(strings.Join([]string{
-		"(function () {",
-		"const console = { __logs__: [], log: function(...args) { this.__logs__.push(['log', new Date(), ...args]) }, };",
-		script,
-		";return { logs: console.__logs__, };",
-		"})",
-	}, "\n"))
-	function, _ := goja.AssertFunction(entry)
-
 	// 执行
+	entry, err := worker.Runtime().RunProgram(program)
+	if err != nil {
+		completed = true
+		Error(w, err)
+		return
+	}
+	function, _ := goja.AssertFunction(entry)
 	value, err := worker.EventLoop().Run(func() (goja.Value, error) {
 		return function(nil)
 	})
@@ -450,3 +716,23 @@ func handleSourceEval(w http.ResponseWriter, r *http.Request) {
 
 	Success(w, data)
 }
+
+// 修改源码后重新查询记录，更新依赖关系、刷新缓存，action 不为空时记录审计日志，返回源码的名称、类型和新的版本号
+func refreshSource(r *http.Request, name string, stype string, status interface{}, action string, detail string) (map[string]interface{}, error) {
+	var source model.Source
+	if err := internal.Db.QueryRow("select name, type, lang, active, method, url, cron, tag, last_modified_date from source where name = ? and type = ?", name, stype).Scan(&source.Name, &source.Type, &source.Lang, &source.Active, &source.Method, &source.Url, &source.Cron, &source.Tag, &source.LastModifiedDate); err != nil {
+		return nil, err
+	}
+	if err := internal.UpdateDependencies(source.Name, source.Type); err != nil {
+		return nil, err
+	}
+	internal.RefreshSource(source, status)
+	if action != "" {
+		audit(r, action, source.Name, source.Type, detail)
+	}
+	return map[string]interface{}{
+		"name":               source.Name,
+		"type":               source.Type,
+		"last_modified_date": source.LastModifiedDate,
+	}, nil
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"testing"
+
+	"cube/internal"
+)
+
+func TestSourceDependents(t *testing.T) {
+	for _, s := range [][3]string{{"dep_lib", "module", `exports.a = 1`}, {"dep_user", "controller", `const lib = require("./dep_lib")`}, {"dep_off", "module", `require("./dep_lib")`}} {
+		if _, err := internal.Db.Exec("insert into source (name, type, lang, content, active) values (?, ?, 'javascript', ?, ?)", s[0], s[1], s[2], s[0] != "dep_off"); err != nil {
+			t.Fatal(err)
+		}
+		if err := internal.UpdateDependencies(s[0], s[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publisher := session(t, "publisher")
+
+	// 仅列出已启用的源码
+	for _, test := range []struct {
+		method string
+		target string
+		body   string
+	}{
+		{"PUT", "/source", `{"name":"dep_lib","type":"module","active":false}`},
+		{"PUT", "/source?force=false", `{"name":"dep_lib","type":"module","active":false}`},
+		{"DELETE", "/source?name=dep_lib&type=module", ""},
+	} {
+		if code, message := request(test.method, test.target, test.body, publisher); code != 400 || message != "dep_lib is still imported by controller dep_user, which will fail to load it; use force to proceed" {
+			t.Errorf("%s %s: %d %s, expected to be refused", test.method, test.target, code, message)
+		}
+	}
+
+	if code, message := request("PUT", "/source?force=true", `{"name":"dep_lib","type":"module","active":false}`, publisher); code != 200 {
+		t.Fatalf("PUT /source?force=true: %d %s", code, message)
+	}
+	// 已停用的源码可以直接删除
+	if code, message := request("DELETE", "/source?name=dep_lib&type=module", "", publisher); code != 200 {
+		t.Fatalf("DELETE /source: %d %s", code, message)
+	}
+}
+
+func TestSourceCrontabOptions(t *testing.T) {
+	if _, err := internal.Db.Exec("insert into source (name, type, lang, content, cron) values ('options', 'crontab', 'javascript', '', '* * * * *')"); err != nil {
+		t.Fatal(err)
+	}
+	publisher := session(t, "publisher")
+
+	for body, expected := range map[string]string{
+		`{"name":"options","type":"crontab","timeout":1.5}`:  "timeout must be an integer",
+		`{"name":"options","type":"crontab","timeout":"10"}`: "timeout must be an integer",
+		`{"name":"options","type":"crontab","retries":null}`: "retries must be an integer",
+		`{"name":"options","type":"crontab","retries":11}`:   "retries must be between 0 and 10",
+	} {
+		if code, message := request("PUT", "/source", body, publisher); code != 400 || message != expected {
+			t.Errorf("PUT %s: %d %s, expected %s", body, code, message, expected)
+		}
+	}
+	if code, message := request("PUT", "/source", `{"name":"options","type":"crontab","timeout":10,"retries":2}`, publisher); code != 200 {
+		t.Errorf("PUT /source: %d %s", code, message)
+	}
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"cube/internal"
+	"cube/internal/model"
+	"cube/internal/util"
+)
+
+// 创建令牌时，各 scope 要求所有者具备的最低角色
+var tokenScopeRoles = map[string]string{
+	"read":  "viewer",
+	"write": "developer",
+	"eval":  "developer",
+	"admin": "admin",
+}
+
+func HandleToken(w http.ResponseWriter, r *http.Request) {
+	// 未创建任何账号时所有接口均无需认证，令牌没有意义
+	me := currentUser(r)
+	if me == nil {
+		Error(w, errors.New("create an account first, tokens are only needed once login is required"))
+		return
+	}
+	// 通过令牌管理令牌须具备 admin scope，以免令牌创建权限更大的令牌
+	if me.Token != "" && !slices.Contains(me.Scopes, "admin") {
+		Error(w, errors.New("permission denied, token scope admin required"))
+		return
+	}
+
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		data, err = handleTokenGet(r, me)
+	case http.MethodPost:
+		data, err = handleTokenPost(r, me)
+	case http.MethodDelete:
+		err = handleTokenDelete(r, me)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+// 查询令牌，admin 查询所有账号的令牌，其余账号仅查询自己的令牌
+func handleTokenGet(r *http.Request, me *model.User) (interface{}, error) {
+	wheres, params := "1 = 1", []interface{}{}
+	if me.Role != "admin" {
+		wheres, params = "user = ?", append(params, me.Name)
+	}
+	rows, err := internal.Db.Query("select id, name, user, scopes, created_date, expires_date, last_used_date from api_token where "+wheres+" order by user, name", params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := make([]model.ApiToken, 0)
+	for rows.Next() {
+		var (
+			t      model.ApiToken
+			scopes string
+		)
+		if err := rows.Scan(&t.Id, &t.Name, &t.User, &scopes, &t.CreatedDate, &t.ExpiresDate, &t.LastUsedDate); err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// 为当前用户创建令牌，令牌仅在响应中返回一次
+func handleTokenPost(r *http.Request, me *model.User) (interface{}, error) {
+	var params struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		Days   int      `json:"days"` // 有效天数，0 表示永不过期
+	}
+	if err := util.UnmarshalWithIoReader(r.Body, &params); err != nil {
+		return nil, err
+	}
+	if !userNamePattern.MatchString(params.Name) {
+		return nil, errors.New("name must be 1 to 64 letters, digits or ._@-")
+	}
+	if len(params.Scopes) == 0 {
+		return nil, errors.New("scopes is required")
+	}
+	for _, scope := range params.Scopes {
+		role, ok := tokenScopeRoles[scope]
+		if !ok {
+			return nil, errors.New("scope must be " + strings.Join(internal.TokenScopes, ", "))
+		}
+		if !internal.HasRole(me.Role, role) {
+			return nil, errors.New("scope " + scope + " requires the " + role + " role")
+		}
+		if me.Token != "" && !slices.Contains(me.Scopes, scope) {
+			return nil, errors.New("scope " + scope + " exceeds the current token")
+		}
+	}
+	if params.Days < 0 {
+		return nil, errors.New("days must not be negative")
+	}
+	// 按固定顺序保存，并去除重复的 scope
+	scopes := slices.DeleteFunc(slices.Clone(internal.TokenScopes), func(s string) bool {
+		return !slices.Contains(params.Scopes, s)
+	})
+
+	token, err := internal.CreateToken(me.Name, params.Name, scopes, params.Days)
+	if err != nil {
+		return nil, err
+	}
+	audit(r, "token_create", params.Name, "", "scopes: "+strings.Join(scopes, ",")+", days: "+strconv.Itoa(params.Days))
+	return map[string]interface{}{
+		"name":  params.Name,
+		"token": token,
+	}, nil
+}
+
+// 吊销令牌，admin 可吊销任意账号的令牌
+func handleTokenDelete(r *http.Request, me *model.User) error {
+	p := &util.QueryParams{Values: r.URL.Query()}
+	id := p.GetIntOrDefault("id", 0)
+	var name, user string
+	if err := internal.Db.QueryRow("select name, user from api_token where id = ?", id).Scan(&name, &user); err != nil || (user != me.Name && me.Role != "admin") {
+		return errors.New("token does not exist")
+	}
+	if _, err := internal.Db.Exec("delete from api_token where id = ?", id); err != nil {
+		return err
+	}
+	audit(r, "token_delete", name, "", "user: "+user)
+	return nil
+}
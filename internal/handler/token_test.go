@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cube/internal"
+)
+
+// 创建令牌，返回设置 Authorization 请求头的函数
+func bearer(t *testing.T, user string, name string, scopes ...string) func(r *http.Request) {
+	token, err := internal.CreateToken(user, name, scopes, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return withToken(token)
+}
+
+func withToken(token string) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func TestTokenScope(t *testing.T) {
+	read := bearer(t, "admin", "scope_read", "read")
+	write := bearer(t, "admin", "scope_write", "read", "write", "eval")
+	admin := bearer(t, "admin", "scope_admin", "read", "admin")
+	viewer := bearer(t, "viewer", "scope_viewer", "read", "write")
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		auth   func(r *http.Request)
+		status int
+		denied string
+	}{
+		{"GET", "/source?type=module", "", withToken("cube_unknown"), 401, "invalid or expired token"},
+		{"GET", "/source?type=module", "", read, 0, ""},
+		{"POST", "/source", `{"name":"token_module","type":"module","lang":"typescript"}`, read, 403, "token scope write required"},
+		{"EVAL", "/source", "", read, 403, "token scope eval required"},
+		{"POST", "/source", `{"name":"token_module","type":"module","lang":"typescript"}`, write, 0, ""},
+
+		// 令牌的权限不超过所有者的角色
+		{"POST", "/source", `{"name":"foo","type":"module","lang":"typescript"}`, viewer, 403, "developer role required"},
+
+		// 没有 admin scope 的令牌不能管理账号、令牌和查看审计日志，即使所有者为 admin
+		{"GET", "/user", "", write, 400, "token scope admin required"},
+		{"POST", "/user", `{"name":"foo","password":"foo-password","role":"admin"}`, write, 400, "token scope admin required"},
+		{"GET", "/token", "", write, 403, "token scope admin required"},
+		{"POST", "/token", `{"name":"foo","scopes":["read"]}`, write, 403, "token scope admin required"},
+		{"GET", "/audit", "", write, 403, "token scope admin required"},
+		{"GET", "/user", "", admin, 0, ""},
+		{"GET", "/token", "", admin, 0, ""},
+		{"GET", "/audit", "", admin, 0, ""},
+	}
+	for _, test := range tests {
+		expectDenied(t, test.method, test.target, test.body, test.auth, test.status, test.denied)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	token, err := internal.CreateToken("viewer", "expired", []string{"read"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectDenied(t, "GET", "/source?type=module", "", withToken(token), 0, "")
+	if _, err := internal.Db.Exec("update api_token set expires_date = datetime('now', 'localtime', '-1 minute') where name = 'expired'"); err != nil {
+		t.Fatal(err)
+	}
+	expectDenied(t, "GET", "/source?type=module", "", withToken(token), 401, "invalid or expired token")
+
+	// 停用所有者后令牌失效
+	token, err = internal.CreateToken("publisher", "disabled", []string{"read"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := internal.Db.Exec("update user set active = false where name = 'publisher'"); err != nil {
+		t.Fatal(err)
+	}
+	defer internal.Db.Exec("update user set active = true where name = 'publisher'")
+	expectDenied(t, "GET", "/source?type=module", "", withToken(token), 401, "invalid or expired token")
+}
+
+func TestTokenCreate(t *testing.T) {
+	developer := session(t, "developer")
+	admin := bearer(t, "admin", "mint_admin", "read", "admin")
+
+	tests := []struct {
+		auth   func(r *http.Request)
+		body   string
+		denied string
+	}{
+		{developer, `{"name":"dev_read","scopes":["read","eval"]}`, ""},
+		{developer, `{"name":"dev_admin","scopes":["admin"]}`, "scope admin requires the admin role"},
+		{session(t, "viewer"), `{"name":"viewer_write","scopes":["write"]}`, "scope write requires the developer role"},
+		{developer, `{"name":"dev_unknown","scopes":["root"]}`, "scope must be read, write, eval, admin"},
+		{developer, `{"name":"dev_empty","scopes":[]}`, "scopes is required"},
+
+		// 令牌不能创建超出自身 scope 的令牌
+		{admin, `{"name":"mint_write","scopes":["read","write"]}`, "scope write exceeds the current token"},
+		{admin, `{"name":"mint_read","scopes":["read"]}`, ""},
+	}
+	for _, test := range tests {
+		code, message := request("POST", "/token", test.body, test.auth)
+		if test.denied == "" && code != http.StatusOK {
+			t.Errorf("POST /token %s: %d %s, expected success", test.body, code, message)
+		}
+		if test.denied != "" && !strings.Contains(message, test.denied) {
+			t.Errorf("POST /token %s: %d %s, expected %s", test.body, code, message, test.denied)
+		}
+	}
+
+	// 创建的令牌仅具备指定的 scope
+	r := httptest.NewRequest("POST", "/token", strings.NewReader(`{"name":"minted","scopes":["read"]}`))
+	admin(r)
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, r)
+	var res struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Data.Token == "" {
+		t.Fatalf("POST /token: %s", w.Body.String())
+	}
+	expectDenied(t, "GET", "/source?type=module", "", withToken(res.Data.Token), 0, "")
+	expectDenied(t, "GET", "/audit", "", withToken(res.Data.Token), 403, "token scope admin required")
+}
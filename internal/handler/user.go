@@ -0,0 +1,330 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"cube/internal"
+	"cube/internal/config"
+	"cube/internal/model"
+	"cube/internal/util"
+)
+
+var userNamePattern = regexp.MustCompile(`^[\w.@-]{1,64}$`)
+
+func HandleUser(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if _, login := query["login"]; login && r.Method == http.MethodPost {
+		handleUserLogin(w, r)
+		return
+	}
+	if _, logout := query["logout"]; logout && r.Method == http.MethodPost {
+		handleUserLogout(w, r)
+		return
+	}
+	// 其余操作须登录，管理其他账号须具备 admin 角色，修改自己的口令除外
+	authenticate("viewer", "viewer", handleUser)(w, r)
+}
+
+func handleUser(w http.ResponseWriter, r *http.Request) {
+	var (
+		data interface{}
+		err  error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		if _, me := r.URL.Query()["me"]; me {
+			data = handleUserMe(r)
+		} else {
+			data, err = handleUserGet(r)
+		}
+	case http.MethodPost:
+		err = handleUserPost(r)
+	case http.MethodPut:
+		err = handleUserPut(r)
+	case http.MethodDelete:
+		err = handleUserDelete(r)
+	default:
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	Success(w, data)
+}
+
+// 校验账号和口令，成功后将会话令牌写入 cookie
+func handleUserLogin(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := util.UnmarshalWithIoReader(r.Body, &params); err != nil {
+		Error(w, err)
+		return
+	}
+	token, user, err := internal.Login(params.Name, params.Password)
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	internal.Audit(model.AuditLog{User: user.Name, Action: "login", RemoteAddr: r.RemoteAddr})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   config.SessionHours * 3600,
+		HttpOnly: true,                    // 禁止脚本读取
+		Secure:   config.Secure,           // 启用 https 时仅通过 https 发送
+		SameSite: http.SameSiteStrictMode, // 防止跨站请求伪造
+	})
+	Success(w, map[string]interface{}{
+		"name": user.Name,
+		"role": user.Role,
+	})
+}
+
+func handleUserLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if err := internal.Logout(c.Value); err != nil {
+			Error(w, err)
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1})
+	Success(w, nil)
+}
+
+// 当前登录的用户，未创建任何账号时视为 admin
+func handleUserMe(r *http.Request) interface{} {
+	user := currentUser(r)
+	if user == nil {
+		return map[string]interface{}{"name": "", "role": "admin", "auth": false}
+	}
+	return map[string]interface{}{"name": user.Name, "role": user.Role, "auth": true}
+}
+
+func handleUserGet(r *http.Request) (interface{}, error) {
+	if err := requireRole(r, "admin"); err != nil {
+		return nil, err
+	}
+	rows, err := internal.Db.Query("select name, role, active, created_date, last_login_date from user order by name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]model.User, 0)
+	for rows.Next() {
+		var u model.User
+		if err := rows.Scan(&u.Name, &u.Role, &u.Active, &u.CreatedDate, &u.LastLoginDate); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// 校验角色和口令
+func checkUser(user model.User) error {
+	if user.Role != "" && !slices.Contains(internal.Roles, user.Role) {
+		return errors.New("role must be " + strings.Join(internal.Roles, ", "))
+	}
+	if user.Password != "" && len(user.Password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+	return nil
+}
+
+func handleUserPost(r *http.Request) error {
+	if err := requireRole(r, "admin"); err != nil {
+		return err
+	}
+	var user model.User
+	if err := util.UnmarshalWithIoReader(r.Body, &user); err != nil {
+		return err
+	}
+	if !userNamePattern.MatchString(user.Name) {
+		return errors.New("name must be 1 to 64 letters, digits or ._@-")
+	}
+	if user.Password == "" {
+		return errors.New("password is required")
+	}
+	if user.Role == "" {
+		return errors.New("role is required")
+	}
+	if err := checkUser(user); err != nil {
+		return err
+	}
+	// 第一个账号须为 admin，否则启用登录后无人可以管理账号
+	if !internal.AuthEnabled() && user.Role != "admin" {
+		return errors.New("the first account must be an admin")
+	}
+
+	hash, err := internal.HashPassword(user.Password)
+	if err != nil {
+		return err
+	}
+	if _, err := internal.Db.Exec("insert into user (name, password, role) values (?, ?, ?)", user.Name, hash, user.Role); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return errors.New("user already exists")
+		}
+		return err
+	}
+	internal.RefreshAuthEnabled()
+	audit(r, "user_create", user.Name, "", "role: "+user.Role)
+	return nil
+}
+
+// 修改账号的角色、启用状态或口令，修改后该账号的会话失效
+// 非 admin 仅可修改自己的口令，且须提供原口令
+func handleUserPut(r *http.Request) error {
+	var params struct {
+		Name        string  `json:"name"`
+		Role        string  `json:"role"`
+		Active      *bool   `json:"active"`
+		Password    string  `json:"password"`
+		OldPassword *string `json:"old_password"`
+	}
+	if err := util.UnmarshalWithIoReader(r.Body, &params); err != nil {
+		return err
+	}
+	if params.Name == "" {
+		return errors.New("name is required")
+	}
+	if err := checkUser(model.User{Role: params.Role, Password: params.Password}); err != nil {
+		return err
+	}
+
+	me := currentUser(r)
+	if me != nil && me.Token != "" && !slices.Contains(me.Scopes, "admin") {
+		return errors.New("permission denied, token scope admin required")
+	}
+	self := me != nil && me.Name == params.Name
+	if params.OldPassword != nil || (me != nil && me.Role != "admin") {
+		if !self || params.Role != "" || params.Active != nil {
+			return errors.New("permission denied, admin role required")
+		}
+		if params.OldPassword == nil {
+			return errors.New("old_password is required")
+		}
+		if _, err := internal.VerifyPassword(params.Name, *params.OldPassword); err != nil {
+			return errors.New("old password is incorrect")
+		}
+	}
+	if self && (params.Role != "" && params.Role != "admin" || params.Active != nil && !*params.Active) {
+		return errors.New("you can not demote or deactivate yourself")
+	}
+
+	var current model.User
+	if err := internal.Db.QueryRow("select name, role, active from user where name = ?", params.Name).Scan(&current.Name, &current.Role, &current.Active); err == sql.ErrNoRows {
+		return errors.New("user does not exist")
+	} else if err != nil {
+		return err
+	}
+
+	sets, values, changes := "", []interface{}{}, []string{}
+	if params.Role != "" && params.Role != current.Role {
+		sets, values, changes = sets+", role = ?", append(values, params.Role), append(changes, "role: "+current.Role+" -> "+params.Role)
+	}
+	if params.Active != nil && *params.Active != current.Active {
+		sets, values, changes = sets+", active = ?", append(values, *params.Active), append(changes, map[bool]string{true: "activated", false: "deactivated"}[*params.Active])
+	}
+	if params.Password != "" {
+		hash, err := internal.HashPassword(params.Password)
+		if err != nil {
+			return err
+		}
+		sets, values, changes = sets+", password = ?", append(values, hash), append(changes, "password changed")
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	if _, err := internal.Db.Exec("update user set "+sets[2:]+" where name = ?", append(values, params.Name)...); err != nil {
+		return err
+	}
+	internal.RefreshAuthEnabled()
+	if err := internal.DeleteSessions(params.Name); err != nil {
+		return err
+	}
+	audit(r, "user_update", params.Name, "", strings.Join(changes, ", "))
+	return nil
+}
+
+func handleUserDelete(r *http.Request) error {
+	if err := requireRole(r, "admin"); err != nil {
+		return err
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if me := currentUser(r); me != nil && me.Name == name {
+		return errors.New("you can not delete yourself")
+	}
+	res, err := internal.Db.Exec("delete from user where name = ?", name)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return errors.New("user does not exist")
+	}
+	internal.RefreshAuthEnabled()
+	if err := internal.DeleteSessions(name); err != nil {
+		return err
+	}
+	if _, err := internal.Db.Exec("delete from api_token where user = ?", name); err != nil {
+		return err
+	}
+	audit(r, "user_delete", name, "", "")
+	return nil
+}
+
+// 查询审计日志，按时间倒序分页
+func HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		Error(w, http.StatusMethodNotAllowed)
+		return
+	}
+	p := &util.QueryParams{Values: r.URL.Query()}
+	from, size := p.GetIntOrDefault("from", 0), p.GetIntOrDefault("size", 10)
+
+	wheres, params := "1 = 1", []interface{}{}
+	for _, c := range []string{"user", "token", "action", "name", "type"} {
+		if p.Has(c) {
+			wheres += " and " + c + " = ?"
+			params = append(params, p.Get(c))
+		}
+	}
+
+	var data struct {
+		Logs  []model.AuditLog `json:"logs"`
+		Total int              `json:"total"`
+	}
+	data.Logs = make([]model.AuditLog, 0, size)
+	if err := internal.Db.QueryRow("select count(1) from audit_log where "+wheres, params...).Scan(&data.Total); err != nil {
+		Error(w, err)
+		return
+	}
+	rows, err := internal.Db.Query("select id, time, user, token, action, name, type, detail, remote_addr from audit_log where "+wheres+" order by id desc limit ?, ?", append(params, from, size)...)
+	if err != nil {
+		Error(w, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l model.AuditLog
+		if err := rows.Scan(&l.Id, &l.Time, &l.User, &l.Token, &l.Action, &l.Name, &l.Type, &l.Detail, &l.RemoteAddr); err != nil {
+			Error(w, err)
+			return
+		}
+		data.Logs = append(data.Logs, l)
+	}
+	Success(w, data)
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"strconv"
+	"time"
+
+	"cube/internal/config"
+	"cube/internal/log"
+)
+
+// PruneSourceHistory 按保留策略清理源码的历史版本：每个源码最多保留 versions 个版本，且仅保留最近 days 天内的版本，为 0 时不限制
+// 每个源码的最新版本始终保留，以便已删除的源码仍可恢复
+func PruneSourceHistory(versions int, days int) (int64, error) {
+	var total int64
+	if versions > 0 {
+		res, err := Db.Exec("delete from source_history where id in (select id from (select id, row_number() over (partition by name, type order by id desc) n from source_history) where n > ?)", versions)
+		if err != nil {
+			return total, err
+		}
+		count, _ := res.RowsAffected()
+		total += count
+	}
+	if days > 0 {
+		res, err := Db.Exec("delete from source_history where created_date < datetime('now', 'localtime', ?) and id not in (select max(id) from source_history group by name, type)", "-"+strconv.Itoa(days)+" days")
+		if err != nil {
+			return total, err
+		}
+		count, _ := res.RowsAffected()
+		total += count
+	}
+	return total, nil
+}
+
+// RunHistoryPruner 在启动时以及此后每小时按命令行参数指定的保留策略清理源码的历史版本
+func RunHistoryPruner() {
+	if config.HistoryVersions <= 0 && config.HistoryDays <= 0 {
+		return
+	}
+	for {
+		if _, err := PruneSourceHistory(config.HistoryVersions, config.HistoryDays); err != nil {
+			log.Error(log.Fields{Worker: -1}, err)
+		}
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,236 @@
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BufferDir 持久化的 daemon 和 crontab 日志所在的目录，每个源码对应一个文件，如 ./logs/daemon/foo.log
+const BufferDir = "./logs"
+
+var (
+	bufferSize    = 0     // 每个 daemon 或 crontab 在内存中保留的日志条数，为 0 时不缓存
+	bufferPersist = false // 是否将缓存的日志持久化，以便重启后仍可查看
+
+	buffers  = make(map[string]*ringBuffer) // 键为 "type/source"
+	bufferMu sync.Mutex
+)
+
+// InitBuffer 初始化 daemon 和 crontab 的日志缓存
+func InitBuffer(size int, persist bool) {
+	bufferSize, bufferPersist = size, persist
+}
+
+// Buffered 判断日志是否按源码单独缓存，仅缓存 daemon 和 crontab 的日志
+func Buffered(stype string, source string) bool {
+	return bufferSize > 0 && source != "" && (stype == "daemon" || stype == "crontab")
+}
+
+// Buffer 返回 daemon 或 crontab 最近的日志，按时间正序排列
+func Buffer(stype string, source string) []*Entry {
+	if !Buffered(stype, source) {
+		return nil
+	}
+	return getBuffer(stype, source).list()
+}
+
+// ClearBuffer 清空 daemon 或 crontab 缓存的日志，包括持久化的文件
+func ClearBuffer(stype string, source string) error {
+	if !Buffered(stype, source) {
+		return nil
+	}
+	return getBuffer(stype, source).clear()
+}
+
+// RemoveBuffer 删除 daemon 或 crontab 缓存的日志，包括持久化的文件，在删除源码后调用
+func RemoveBuffer(stype string, source string) error {
+	if !Buffered(stype, source) {
+		return nil
+	}
+	bufferMu.Lock()
+	key := stype + "/" + source
+	b, ok := buffers[key]
+	delete(buffers, key)
+	bufferMu.Unlock()
+
+	if ok {
+		return b.clear()
+	}
+	if bufferPersist { // 启动后未加载过的日志仅存在于文件中
+		if err := os.Remove(bufferFile(stype, source)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func buffer(e *Entry) {
+	if Buffered(e.Type, e.Source) {
+		getBuffer(e.Type, e.Source).push(e)
+	}
+}
+
+func getBuffer(stype string, source string) *ringBuffer {
+	bufferMu.Lock()
+	defer bufferMu.Unlock()
+
+	key := stype + "/" + source
+	b, ok := buffers[key]
+	if !ok {
+		b = &ringBuffer{entries: make([]*Entry, bufferSize)}
+		if bufferPersist {
+			b.name = bufferFile(stype, source)
+			b.load()
+		}
+		buffers[key] = b
+	}
+	return b
+}
+
+// 持久化文件的路径，源码名称中可能包含 "/"，因此需要转义
+func bufferFile(stype string, source string) string {
+	return filepath.Join(BufferDir, stype, url.PathEscape(source)+".log")
+}
+
+//#region 环形缓冲区
+
+type ringBuffer struct {
+	sync.Mutex
+	entries []*Entry
+	start   int      // 最早一条日志的下标
+	count   int      // 日志条数
+	name    string   // 持久化文件的路径，为空时不持久化
+	fd      *os.File // 持久化文件，按 JSON Lines 格式追加写入
+	lines   int      // 持久化文件的行数，超过缓存条数的两倍时压缩，以限制文件的大小
+}
+
+func (b *ringBuffer) push(e *Entry) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.name == "" {
+		b.add(e)
+		return
+	}
+
+	if b.lines >= 2*len(b.entries) {
+		b.compact()
+	}
+	b.add(e)
+	if b.fd == nil && b.open() != nil {
+		return
+	}
+	if data, err := json.Marshal(e); err == nil {
+		b.fd.Write(append(data, '\n'))
+		b.lines++
+	}
+}
+
+func (b *ringBuffer) add(e *Entry) {
+	if b.count < len(b.entries) {
+		b.entries[(b.start+b.count)%len(b.entries)] = e
+		b.count++
+		return
+	}
+	b.entries[b.start] = e // 已满时覆盖最早一条日志
+	b.start = (b.start + 1) % len(b.entries)
+}
+
+func (b *ringBuffer) list() []*Entry {
+	b.Lock()
+	defer b.Unlock()
+
+	entries := make([]*Entry, 0, b.count)
+	for i := 0; i < b.count; i++ {
+		entries = append(entries, b.entries[(b.start+i)%len(b.entries)])
+	}
+	return entries
+}
+
+func (b *ringBuffer) clear() error {
+	b.Lock()
+	defer b.Unlock()
+
+	clear(b.entries)
+	b.start, b.count = 0, 0
+
+	if b.name == "" {
+		return nil
+	}
+	if b.fd != nil {
+		b.fd.Close()
+		b.fd = nil
+	}
+	b.lines = 0
+	if err := os.Remove(b.name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 从持久化文件中加载最近的日志
+func (b *ringBuffer) load() {
+	fd, err := os.Open(b.name)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+
+	reader := bufio.NewReader(fd)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			e := &Entry{}
+			if json.Unmarshal(line, e) == nil {
+				b.add(e)
+				b.lines++
+			}
+		}
+		if err != nil { // 包括 io.EOF
+			return
+		}
+	}
+}
+
+func (b *ringBuffer) open() error {
+	if err := os.MkdirAll(filepath.Dir(b.name), 0o755); err != nil {
+		return err
+	}
+	fd, err := os.OpenFile(b.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	b.fd = fd
+	return nil
+}
+
+// 使用内存中的日志重写持久化文件，丢弃已被覆盖的日志
+func (b *ringBuffer) compact() {
+	if b.fd != nil {
+		b.fd.Close()
+		b.fd = nil
+	}
+
+	fd, err := os.Create(b.name + ".tmp")
+	if err != nil {
+		return
+	}
+	w := bufio.NewWriter(fd)
+	for i := 0; i < b.count; i++ {
+		if data, err := json.Marshal(b.entries[(b.start+i)%len(b.entries)]); err == nil {
+			w.Write(append(data, '\n'))
+		}
+	}
+	w.Flush()
+	fd.Close()
+
+	if os.Rename(b.name+".tmp", b.name) == nil {
+		b.lines = b.count
+	}
+}
+
+//#endregion
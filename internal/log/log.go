@@ -1,36 +1,301 @@
 package log
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-func Init() {
-	fd, err := os.OpenFile("./cube.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-	if err != nil {
+const (
+	File       = "./cube.log"
+	AccessFile = "./access.log"
+)
+
+// 日志级别，数值越大级别越高
+var Levels = map[string]int{
+	"debug": 0,
+	"log":   1,
+	"info":  2,
+	"warn":  3,
+	"error": 4,
+}
+
+// Fields 日志的来源信息
+type Fields struct {
+	Worker    int    `json:"worker"`
+	Source    string `json:"source,omitempty"` // 源码名称
+	Type      string `json:"type,omitempty"`   // 源码类型，如 controller、daemon、crontab
+	RequestId string `json:"request_id,omitempty"`
+}
+
+// Entry 单条日志记录，按 JSON Lines 格式写入日志文件
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+	Fields
+}
+
+// AccessEntry 单条 HTTP 访问日志记录
+type AccessEntry struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Proto      string    `json:"proto"`
+	Controller string    `json:"controller,omitempty"` // 匹配到的 controller 名称
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Latency    float64   `json:"latency"` // 耗时，单位毫秒
+	RemoteAddr string    `json:"remote_addr"`
+	Worker     int       `json:"worker"` // 未使用 worker 时为 -1
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent"`
+	RequestId  string    `json:"request_id"`
+}
+
+var (
+	level      = "debug" // 最低写入级别
+	maxSize    = 64      // 单个日志文件的大小上限，单位 MB
+	maxBackups = 7       // 历史日志文件的保留个数
+	access     = "json"  // 访问日志的格式：json、combined 或 off
+
+	writer       = &rotateWriter{name: File}
+	accessWriter = &rotateWriter{name: AccessFile}
+
+	subscribers  = make(map[chan *Entry]struct{}) // 实时日志的订阅者
+	subscriberMu sync.RWMutex
+
+	echo io.Writer // 日志的同步输出，如命令行执行脚本时输出到 stderr
+)
+
+func Init(minLevel string, maxFileSize int, maxFileBackups int, accessFormat string) {
+	if _, ok := Levels[minLevel]; ok {
+		level = minLevel
+	}
+	maxSize, maxBackups, access = maxFileSize, maxFileBackups, accessFormat
+
+	if err := writer.open(); err != nil {
 		panic(err)
 	}
-	log.SetOutput(fd)
-	log.SetFlags(log.Lmsgprefix) // 去除日志每行开头自带的时间戳前缀
+	if access != "off" {
+		if err := accessWriter.open(); err != nil {
+			panic(err)
+		}
+	}
+	log.SetOutput(writer) // 标准库 log 的输出同样写入日志文件
+	log.SetFlags(log.Lmsgprefix)
+}
+
+func Log(f Fields, e ...interface{}) {
+	write("log", f, e...)
+}
+
+func Debug(f Fields, e ...interface{}) {
+	write("debug", f, e...)
+}
+
+func Info(f Fields, e ...interface{}) {
+	write("info", f, e...)
+}
+
+func Warn(f Fields, e ...interface{}) {
+	write("warn", f, e...)
 }
 
-func Log(id int, e ...interface{}) {
-	log.Println(append([]interface{}{time.Now().Format("2006-01-02 15:04:05.000"), id, "Log"}, e...)...)
+func Error(f Fields, e ...interface{}) {
+	write("error", f, e...)
 }
 
-func Debug(id int, e ...interface{}) {
-	log.Println(append(append([]interface{}{"\033[1;30m" + time.Now().Format("2006-01-02 15:04:05.000"), id, "Debug"}, e...), "\033[m")...)
+// Access 写入访问日志
+func Access(e *AccessEntry) {
+	switch access {
+	case "off":
+		return
+	case "combined": // 在 Apache combined 格式的基础上，追加请求 ID、controller、worker 和耗时
+		worker := "-"
+		if e.Worker >= 0 {
+			worker = strconv.Itoa(e.Worker)
+		}
+		fmt.Fprintf(accessWriter, "%s - - [%s] %q %d %d %q %q %s %s %s %.3fms\n",
+			e.RemoteAddr,
+			e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			e.Method+" "+e.Path+" "+e.Proto,
+			e.Status,
+			e.Bytes,
+			e.Referer,
+			e.UserAgent,
+			e.RequestId,
+			orDash(e.Controller),
+			worker,
+			e.Latency,
+		)
+	default:
+		b, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		accessWriter.Write(append(b, '\n'))
+	}
 }
 
-func Info(id int, e ...interface{}) {
-	log.Println(append(append([]interface{}{"\033[0;34m" + time.Now().Format("2006-01-02 15:04:05.000"), id, "Info"}, e...), "\033[m")...)
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
 }
 
-func Warn(id int, e ...interface{}) {
-	log.Println(append(append([]interface{}{"\033[0;33m" + time.Now().Format("2006-01-02 15:04:05.000"), id, "Warn"}, e...), "\033[m")...)
+// Echo 将之后写入的日志同时以 "级别 内容" 的格式输出到 w，w 为 nil 时取消
+func Echo(w io.Writer) {
+	echo = w
 }
 
-func Error(id int, e ...interface{}) {
-	log.Println(append(append([]interface{}{"\033[0;31m" + time.Now().Format("2006-01-02 15:04:05.000"), id, "Error"}, e...), "\033[m")...)
+// Subscribe 订阅实时日志，返回的取消方法须在订阅结束后调用
+func Subscribe() (<-chan *Entry, func()) {
+	c := make(chan *Entry, 64)
+
+	subscriberMu.Lock()
+	subscribers[c] = struct{}{}
+	subscriberMu.Unlock()
+
+	return c, func() {
+		subscriberMu.Lock()
+		delete(subscribers, c)
+		subscriberMu.Unlock()
+	}
 }
+
+func write(l string, f Fields, e ...interface{}) {
+	if Levels[l] < Levels[level] {
+		return
+	}
+
+	entry := &Entry{
+		Time:    time.Now(),
+		Level:   l,
+		Message: strings.TrimSuffix(fmt.Sprintln(e...), "\n"), // 与 log.Println 一致，使用空格拼接各参数
+		Fields:  f,
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	writer.Write(append(b, '\n'))
+	if echo != nil {
+		fmt.Fprintf(echo, "%-5s %s\n", entry.Level, entry.Message)
+	}
+
+	buffer(entry)
+
+	subscriberMu.RLock()
+	for c := range subscribers {
+		select {
+		case c <- entry:
+		default: // 订阅者消费过慢时丢弃，防止阻塞日志写入
+		}
+	}
+	subscriberMu.RUnlock()
+}
+
+//#region 日志滚动
+
+type rotateWriter struct {
+	sync.Mutex
+	name string
+	fd   *os.File
+	size int64
+	date string
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.fd == nil {
+		return 0, os.ErrClosed
+	}
+
+	// 当文件大小超过上限或者日期变更时，滚动日志文件
+	if date := time.Now().Format("20060102"); w.size+int64(len(p)) > int64(maxSize)<<20 || date != w.date {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.fd.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotateWriter) open() error {
+	fd, err := os.OpenFile(w.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return err
+	}
+	w.fd, w.size, w.date = fd, info.Size(), info.ModTime().Format("20060102")
+	return nil
+}
+
+func (w *rotateWriter) rotate() error {
+	if w.size > 0 {
+		// 同一秒内多次滚动时追加序号，以免覆盖已滚动的文件，序号补零以便按字典序排序
+		backup := w.name + "." + time.Now().Format("20060102150405")
+		for i := 1; ; i++ {
+			if _, err := os.Stat(backup); os.IsNotExist(err) {
+				break
+			}
+			backup = fmt.Sprintf("%s.%s-%03d", w.name, time.Now().Format("20060102150405"), i)
+		}
+		err := os.Rename(w.name, backup) // 重命名成功后再关闭文件
+		w.fd.Close()
+		if err != nil {
+			// Windows 下无法重命名已打开的文件，关闭后再次尝试，仍失败时重新打开当前的日志文件继续追加，下次写入时再次尝试滚动
+			if err := os.Rename(w.name, backup); err != nil {
+				return w.open()
+			}
+		}
+		w.prune()
+	} else {
+		w.fd.Close() // 空文件无需重命名，但仍须关闭后重新打开，以免文件描述符泄漏
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.date = time.Now().Format("20060102")
+	return nil
+}
+
+// Files 日志文件及其滚动后的历史文件，按时间顺序排列，最后一个为当前的日志文件
+func Files() []string {
+	files, _ := filepath.Glob(File + ".*")
+	sort.Strings(files) // 文件名后缀为时间戳，按字典序排序即按时间排序
+	return append(files, File)
+}
+
+// 删除超出保留个数的历史日志文件
+func (w *rotateWriter) prune() {
+	files, _ := filepath.Glob(w.name + ".*")
+	if len(files) <= maxBackups {
+		return
+	}
+	sort.Strings(files) // 文件名后缀为时间戳，按字典序排序即按时间排序
+	for _, f := range files[:len(files)-maxBackups] {
+		os.Remove(f)
+	}
+}
+
+//#endregion
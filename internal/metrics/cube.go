@@ -0,0 +1,16 @@
+package metrics
+
+// 以下为由业务代码直接记录的指标，worker 池、daemon、进程资源等指标在采集时计算，见 internal.RunMonitor 所在文件
+var (
+	WorkerWaits        = NewHistogramVec("cube_worker_wait_seconds", "Time spent waiting for an idle worker.", DefBuckets)
+	WorkerRejections   = NewCounterVec("cube_worker_rejections_total", "Requests rejected with 503 because no worker was idle.")
+	ControllerRequests = NewCounterVec("cube_controller_requests_total", "Requests handled by each controller.", "controller")
+	ControllerErrors   = NewCounterVec("cube_controller_errors_total", "Requests that failed with a script error, by controller.", "controller")
+	ControllerDuration = NewHistogramVec("cube_controller_duration_seconds", "Controller execution time.", DefBuckets, "controller")
+	CrontabRuns        = NewCounterVec("cube_crontab_runs_total", "Crontab executions by result.", "crontab", "result")
+	CrontabDuration    = NewHistogramVec("cube_crontab_duration_seconds", "Crontab execution time.", DefBuckets, "crontab")
+	DaemonStarts       = NewCounterVec("cube_daemon_starts_total", "Times each daemon has been started.", "daemon")
+	DaemonRestarts     = NewCounterVec("cube_daemon_restarts_total", "Times each daemon has been restarted automatically after exiting.", "daemon")
+	ModuleCacheHits    = NewCounterVec("cube_module_cache_hits_total", "Module lookups served from the compiled program cache.")
+	ModuleCacheMisses  = NewCounterVec("cube_module_cache_misses_total", "Module lookups that required loading and compiling the source.")
+)
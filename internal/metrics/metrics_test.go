@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	registryMu.Lock()
+	saved := registry
+	registry = nil
+	registryMu.Unlock()
+	defer func() {
+		registryMu.Lock()
+		registry = saved
+		registryMu.Unlock()
+	}()
+
+	c := NewCounterVec("test_requests_total", "Requests.", "name")
+	c.Inc("a\"b")
+	c.Add(2, "c")
+	h := NewHistogramVec("test_duration_seconds", "Duration.", []float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+	NewGaugeFunc("test_up", "Up.", func() []Sample {
+		return []Sample{{Value: 1}}
+	})
+
+	buf := &bytes.Buffer{}
+	Write(buf)
+
+	expected := []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{name="a\"b"} 1`,
+		`test_requests_total{name="c"} 2`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{le="0.1"} 1`,
+		`test_duration_seconds_bucket{le="1"} 2`,
+		`test_duration_seconds_bucket{le="+Inf"} 3`,
+		"test_duration_seconds_sum 5.55",
+		"test_duration_seconds_count 3",
+		"# TYPE test_up gauge",
+		"test_up 1",
+	}
+	for _, line := range expected {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, buf.String())
+		}
+	}
+}
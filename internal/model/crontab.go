@@ -0,0 +1,16 @@
+package model
+
+import "cube/internal/util"
+
+type CrontabRun struct {
+	Id        int        `json:"id"`
+	Name      string     `json:"name"`
+	Manual    bool       `json:"manual"` // 是否为手动触发
+	Status    string     `json:"status"` // running, success, error, skipped
+	StartTime util.Time  `json:"start_time"`
+	EndTime   *util.Time `json:"end_time"`
+	Duration  int64      `json:"duration"` // 执行时长，单位毫秒
+	Error     string     `json:"error"`
+	Result    string     `json:"result"`   // JSON 序列化后的返回值，超长时被截断
+	Attempts  int        `json:"attempts"` // 执行次数，包括失败后的重试
+}
@@ -0,0 +1,9 @@
+package model
+
+type Dependency struct {
+	Module string `json:"module"` // 模块 id，如 "./lib/date"、"lodash"、"https://..."
+	Name   string `json:"name"`   // 对应的源码名称，链接模块为链接本身
+	Type   string `json:"type"`   // module、controller、daemon、crontab，链接模块为 link
+	Exists bool   `json:"exists"`
+	Active bool   `json:"active"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import "cube/internal/util"
+
+type SourceHistory struct {
+	Id               int        `json:"id"`
+	Action           string     `json:"action"` // insert, update, delete
+	Name             string     `json:"name"`
+	Type             string     `json:"type"`
+	Lang             string     `json:"lang"`
+	Content          string     `json:"content,omitempty"`
+	Compiled         string     `json:"compiled,omitempty"`
+	SourceMap        string     `json:"source_map,omitempty"`
+	Active           bool       `json:"active"`
+	Method           string     `json:"method"`
+	Url              string     `json:"url"`
+	Cron             string     `json:"cron"`
+	Tag              string     `json:"tag"`
+	Timeout          int        `json:"timeout"`
+	Overlap          string     `json:"overlap"`
+	Retries          int        `json:"retries"`
+	Restart          string     `json:"restart"`
+	Size             int        `json:"size"` // 源码的字节数
+	LastModifiedDate *util.Time `json:"last_modified_date"`
+	CreatedDate      util.Time  `json:"created_date"`
+}
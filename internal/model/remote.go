@@ -0,0 +1,12 @@
+package model
+
+import "cube/internal/util"
+
+type RemoteModule struct {
+	Url         string    `json:"url"`
+	Content     string    `json:"content,omitempty"`
+	Sha256      string    `json:"sha256"`
+	Pinned      bool      `json:"pinned"` // 是否锁定，锁定后刷新时内容不允许变更
+	Size        int       `json:"size"`
+	FetchedDate util.Time `json:"fetched_date"`
+}
@@ -0,0 +1,18 @@
+package model
+
+type SearchResult struct {
+	Id     int          `json:"rowid"`
+	Name   string       `json:"name"`
+	Type   string       `json:"type"`
+	Lang   string       `json:"lang"`
+	Active bool         `json:"active"`
+	Count  int          `json:"count"` // 匹配的次数
+	Lines  []SearchLine `json:"lines"` // 匹配的行，超过上限的行不返回
+}
+
+type SearchLine struct {
+	Line   int      `json:"line"`   // 行号，从 1 开始
+	Column int      `json:"column"` // 首个匹配的列号，从 1 开始
+	Text   string   `json:"text"`   // 行的内容，过长时截取首个匹配附近的片段
+	Ranges [][2]int `json:"ranges"` // 匹配在 text 中的起止位置，按 UTF-16 编码单元计算，与 JavaScript 字符串的下标一致
+}
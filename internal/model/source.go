@@ -3,17 +3,28 @@ package model
 import "cube/internal/util"
 
 type Source struct {
-	Id               int       `json:"rowid"`
-	Name             string    `json:"name"`
-	Type             string    `json:"type"` // module, controller, daemon, crontab, template, resource
-	Lang             string    `json:"lang"` // typescript, html, text, vue
-	Content          string    `json:"content,omitempty"`
-	Compiled         string    `json:"compiled,omitempty"`
-	Active           bool      `json:"active"`
-	Method           string    `json:"method"`
-	Url              string    `json:"url"`
-	Cron             string    `json:"cron"`
-	Tag              string    `json:"tag"`
-	LastModifiedDate util.Time `json:"last_modified_date"`
-	Status           string    `json:"status"`
+	Id                int        `json:"rowid"`
+	Name              string     `json:"name"`
+	Type              string     `json:"type"` // module, controller, daemon, crontab, template, resource
+	Lang              string     `json:"lang"` // typescript, html, text, vue
+	Content           string     `json:"content,omitempty"`
+	Compiled          string     `json:"compiled,omitempty"`
+	SourceMap         string     `json:"source_map,omitempty"`
+	Active            bool       `json:"active"`
+	Method            string     `json:"method"`
+	Url               string     `json:"url"`
+	Cron              string     `json:"cron"`
+	Timeout           int        `json:"timeout"` // 定时任务的最大执行时长，单位秒，0 表示不限制
+	Overlap           string     `json:"overlap"` // 定时任务上一次执行未完成时的策略：allow、skip、queue
+	Retries           int        `json:"retries"` // 定时任务执行失败后的重试次数
+	Restart           string     `json:"restart"` // daemon 退出后的重启策略：never、on-failure、always
+	Tag               string     `json:"tag"`
+	LastModifiedDate  util.Time  `json:"last_modified_date"`
+	Draft             bool       `json:"draft"`                         // 是否存在未发布的草稿
+	DraftContent      string     `json:"draft_content,omitempty"`       // 草稿的源码内容
+	DraftModifiedDate *util.Time `json:"draft_modified_date,omitempty"` // 草稿的最后修改时间
+	Status            string     `json:"status"`                        // daemon 的运行状态：true（运行中）、stopping（正在停止）、restarting（等待重启）、crashloop（崩溃循环，已停止重启）、false（已停止）
+	Restarts          int        `json:"restarts,omitempty"`            // daemon 自动重启的次数
+	LastExit          string     `json:"last_exit,omitempty"`           // daemon 最近一次退出的原因
+	LastExitTime      *util.Time `json:"last_exit_time,omitempty"`      // daemon 最近一次退出的时间
 }
@@ -0,0 +1,36 @@
+package model
+
+import "cube/internal/util"
+
+type User struct {
+	Name          string     `json:"name"`
+	Password      string     `json:"password,omitempty"` // 仅用于新增和修改，查询时不返回
+	Role          string     `json:"role"`               // viewer, developer, publisher, admin
+	Active        bool       `json:"active"`
+	CreatedDate   util.Time  `json:"created_date"`
+	LastLoginDate *util.Time `json:"last_login_date"`
+	Token         string     `json:"-"` // 通过 API 令牌访问时为令牌的名称
+	Scopes        []string   `json:"-"` // 通过 API 令牌访问时为令牌的权限范围
+}
+
+type ApiToken struct {
+	Id           int        `json:"id"`
+	Name         string     `json:"name"`
+	User         string     `json:"user"`   // 令牌的所有者
+	Scopes       []string   `json:"scopes"` // read, write, eval, admin
+	CreatedDate  util.Time  `json:"created_date"`
+	ExpiresDate  *util.Time `json:"expires_date"` // 为空时永不过期
+	LastUsedDate *util.Time `json:"last_used_date"`
+}
+
+type AuditLog struct {
+	Id         int       `json:"id"`
+	Time       util.Time `json:"time"`
+	User       string    `json:"user"`
+	Token      string    `json:"token"` // 通过 API 令牌操作时为令牌的名称
+	Action     string    `json:"action"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Detail     string    `json:"detail"`
+	RemoteAddr string    `json:"remote_addr"`
+}
@@ -0,0 +1,24 @@
+package model
+
+// SourceMeta 导出到目录时源码文件以外的属性，保存在同名的 .meta.json 文件中，仅包含与源码类型相关的属性
+type SourceMeta struct {
+	Active           bool   `json:"active"`
+	Method           string `json:"method,omitempty"`
+	Url              string `json:"url,omitempty"`
+	Cron             string `json:"cron,omitempty"`
+	Timeout          int    `json:"timeout,omitempty"`
+	Overlap          string `json:"overlap,omitempty"`
+	Retries          int    `json:"retries,omitempty"`
+	Restart          string `json:"restart,omitempty"`
+	Tag              string `json:"tag,omitempty"`
+	LastModifiedDate string `json:"last_modified_date"` // 最后一次同步时数据库中的版本号，用于检测冲突
+	Sha256           string `json:"sha256"`             // 最后一次同步时源码文件内容的摘要，用于检测本地的修改
+}
+
+type SyncResult struct {
+	Path    string `json:"path"` // 源码文件相对于目录的路径，如 controller/foo.ts
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Action  string `json:"action"` // created、updated、unchanged、skipped、conflict、error
+	Message string `json:"message,omitempty"`
+}
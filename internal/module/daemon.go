@@ -0,0 +1,221 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cube/internal/builtin"
+	"cube/internal/log"
+	"cube/internal/util"
+
+	"github.com/dop251/goja"
+)
+
+// DaemonMessageTimeout 向 daemon 发送消息时，等待回复的默认时长
+const DaemonMessageTimeout = 10 * time.Second
+
+func init() {
+	register("daemon", func(ctx Context) interface{} {
+		return &DaemonClient{ctx}
+	})
+}
+
+//#region daemon 信号
+
+// DaemonSignal 作为 daemon 入口函数的第一个参数注入，用于接收停止通知和控制消息
+type DaemonSignal struct {
+	name      string
+	worker    builtin.Worker
+	stopped   atomic.Bool     // 是否已收到停止通知，可在任意协程中读取
+	handling  atomic.Bool     // 是否已注册消息处理方法
+	done      chan struct{}   // daemon 退出后关闭，此后不再向事件循环投递任务
+	mu        sync.RWMutex    // 保护投递过程，确保 Close 返回后不再有任务进入事件循环
+	onStop    []goja.Callable // 以下字段仅在事件循环所在的协程中访问
+	onMessage goja.Callable
+	trigger   *builtin.EventTaskTrigger // 注册消息处理方法后保持事件循环运行，直到 daemon 被停止
+}
+
+// NewDaemonSignal 为即将运行的 daemon 创建信号，并登记到运行中的 daemon 列表，daemon 退出后需要调用 Close 方法
+func NewDaemonSignal(name string, worker builtin.Worker) *DaemonSignal {
+	s := &DaemonSignal{
+		name:   name,
+		worker: worker,
+		done:   make(chan struct{}),
+	}
+
+	daemons.Lock()
+	if daemons.signals == nil {
+		daemons.signals = make(map[string]*DaemonSignal)
+	}
+	daemons.signals[name] = s
+	daemons.Unlock()
+
+	return s
+}
+
+// Value 创建注入到脚本中的 signal 对象，需要在 worker 执行脚本之前调用
+func (s *DaemonSignal) Value() goja.Value {
+	runtime := s.worker.Runtime()
+
+	o := runtime.NewObject()
+	o.DefineAccessorProperty("stopped", runtime.ToValue(func() bool {
+		return s.stopped.Load()
+	}), nil, goja.FLAG_FALSE, goja.FLAG_TRUE)
+	o.Set("onStop", func(call goja.FunctionCall) goja.Value {
+		fn, ok := goja.AssertFunction(call.Argument(0))
+		if !ok {
+			s.worker.Interrupt("invalid argument callback, not a function")
+			return nil
+		}
+		if s.stopped.Load() { // 已收到停止通知，直接执行
+			s.call(fn)
+			return nil
+		}
+		s.onStop = append(s.onStop, fn)
+		return nil
+	})
+	o.Set("onMessage", func(call goja.FunctionCall) goja.Value {
+		fn, ok := goja.AssertFunction(call.Argument(0))
+		if !ok {
+			s.worker.Interrupt("invalid argument handler, not a function")
+			return nil
+		}
+		s.onMessage = fn
+		if s.trigger == nil && !s.stopped.Load() {
+			s.trigger = s.worker.EventLoop().NewEventTaskTrigger()
+		}
+		s.handling.Store(true)
+		return nil
+	})
+	return o
+}
+
+// Stop 通知 daemon 停止：stopped 属性变为 true，并在事件循环中依次执行 onStop 回调
+func (s *DaemonSignal) Stop() {
+	if s.stopped.Swap(true) {
+		return
+	}
+	go s.post(func() {
+		for _, fn := range s.onStop {
+			s.call(fn)
+		}
+		if s.trigger != nil { // 不再保持事件循环运行，其余异步任务结束后 daemon 即退出
+			s.trigger.Cancel()
+		}
+	})
+}
+
+// Send 向 daemon 发送消息，并等待 onMessage 处理方法的返回值作为回复，返回值为 Promise 时等待其完成
+// from 为发送消息的 worker，通过 HTTP 发送时为 nil
+func (s *DaemonSignal) Send(message interface{}, timeout time.Duration, from builtin.Worker) (interface{}, error) {
+	if from != nil && from == s.worker { // 处理方法在 daemon 自身的事件循环中执行，同步等待回复只会超时
+		return nil, fmt.Errorf("daemon %s can not send a message to itself", s.name)
+	}
+	if !s.handling.Load() {
+		return nil, fmt.Errorf("daemon %s does not handle messages", s.name)
+	}
+
+	type reply struct {
+		value interface{}
+		err   error
+	}
+	replies := make(chan reply, 1) // 在等待超时后，回复将被丢弃，因此这里需要缓冲以免阻塞事件循环
+
+	go s.post(func() {
+		runtime := s.worker.Runtime()
+		v, err := s.onMessage(nil, runtime.ToValue(message))
+		if err != nil {
+			replies <- reply{nil, err}
+			return
+		}
+		if p, ok := v.Export().(*goja.Promise); ok && p.State() == goja.PromiseStatePending {
+			then, _ := goja.AssertFunction(v.ToObject(runtime).Get("then"))
+			then(v, runtime.ToValue(func(r goja.Value) {
+				value, err := util.ExportGojaValue(r)
+				replies <- reply{value, err}
+			}), runtime.ToValue(func(e goja.Value) {
+				replies <- reply{nil, errors.New(e.String())}
+			}))
+			return
+		}
+		value, err := util.ExportGojaValue(v)
+		replies <- reply{value, err}
+	})
+
+	select {
+	case r := <-replies:
+		return r.value, r.err
+	case <-s.done:
+		return nil, fmt.Errorf("daemon %s exited before replying", s.name)
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("daemon %s did not reply within %s", s.name, timeout)
+	}
+}
+
+// Close 在 daemon 退出后、worker 重置前调用，将 daemon 从运行中的 daemon 列表中移除，并停止投递任务
+func (s *DaemonSignal) Close() {
+	daemons.Lock()
+	if daemons.signals[s.name] == s {
+		delete(daemons.signals, s.name)
+	}
+	daemons.Unlock()
+
+	close(s.done)
+	s.mu.Lock() // 等待投递中的任务结束，以免其进入重置后的事件循环
+	s.mu.Unlock()
+}
+
+func (s *DaemonSignal) post(fn func()) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.worker.EventLoop().Post(fn, s.done)
+}
+
+func (s *DaemonSignal) call(fn goja.Callable) {
+	if _, err := fn(nil); err != nil {
+		log.Error(s.worker.LogFields(), err)
+	}
+}
+
+//#endregion
+
+//#region 运行中的 daemon
+
+var daemons struct {
+	sync.RWMutex
+	signals map[string]*DaemonSignal
+}
+
+// GetDaemonSignal 获取运行中的 daemon 的信号
+func GetDaemonSignal(name string) (*DaemonSignal, bool) {
+	daemons.RLock()
+	defer daemons.RUnlock()
+	s, ok := daemons.signals[name]
+	return s, ok
+}
+
+// SendDaemonMessage 向运行中的 daemon 发送消息并等待回复，from 为发送消息的 worker，通过 HTTP 发送时为 nil
+func SendDaemonMessage(name string, message interface{}, timeout time.Duration, from builtin.Worker) (interface{}, error) {
+	s, ok := GetDaemonSignal(name)
+	if !ok {
+		return nil, fmt.Errorf("daemon %s is not running", name)
+	}
+	if timeout <= 0 {
+		timeout = DaemonMessageTimeout
+	}
+	return s.Send(message, timeout, from)
+}
+
+//#endregion
+
+type DaemonClient struct {
+	ctx Context
+}
+
+// Send 向运行中的 daemon 发送消息并等待回复，timeout 单位为毫秒
+func (c *DaemonClient) Send(name string, message interface{}, timeout int) (interface{}, error) {
+	return SendDaemonMessage(name, message, time.Duration(timeout)*time.Millisecond, c.ctx.Worker)
+}
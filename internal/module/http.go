@@ -20,7 +20,7 @@ import (
 func init() {
 	register("http", func(ctx Context) interface{} {
 		return func(options *HttpOptions) (*HttpClient, error) {
-			httpc := &HttpClient{c: &http.Client{}}
+			httpc := &HttpClient{c: &http.Client{}, worker: ctx.Worker}
 
 			if options == nil {
 				return httpc, nil
@@ -116,7 +116,8 @@ type FormData struct {
 }
 
 type HttpClient struct {
-	c *http.Client
+	c      *http.Client
+	worker builtin.Worker
 }
 
 func (h *HttpClient) Request(method string, url string, header map[string]string, input interface{}) (response interface{}, err error) {
@@ -149,6 +150,9 @@ func (h *HttpClient) Request(method string, url string, header map[string]string
 	if contentType != "" {
 		req.Header.Set("Content-Type", contentType)
 	}
+	if id := h.worker.RequestId(); id != "" && req.Header.Get("X-Request-Id") == "" { // 透传当前请求 ID
+		req.Header.Set("X-Request-Id", id)
+	}
 
 	resp, err := h.c.Do(req)
 	if err != nil {
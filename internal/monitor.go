@@ -3,17 +3,102 @@ package internal
 import (
 	"fmt"
 	"os"
+	"runtime"
+	"sync/atomic"
 	"time"
 
+	"cube/internal/cache"
+	"cube/internal/metrics"
+
 	"github.com/shirou/gopsutil/process"
 )
 
+// 服务是否已就绪，在虚拟机池、缓存、守护任务和定时任务均初始化完成且开始监听端口后置为 true
+var Ready atomic.Bool
+
+// 当前进程，用于采集 cpu 和内存的使用情况
+var self, _ = process.NewProcess(int32(os.Getpid()))
+
+func init() {
+	// worker 池
+	metrics.NewGaugeFunc("cube_worker_pool_size", "Number of workers in the pool.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(len(WorkerPool.Workers))}}
+	})
+	metrics.NewGaugeFunc("cube_worker_pool_busy", "Number of workers currently executing a script.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(len(WorkerPool.Workers) - len(WorkerPool.Channels))}}
+	})
+
+	// 已启用的 daemon 是否正在运行
+	metrics.NewGaugeFunc("cube_daemon_up", "Whether an active daemon is currently running (1) or not (0).", func() []metrics.Sample {
+		samples := make([]metrics.Sample, 0)
+		rows, err := Db.Query("select name from source where type = 'daemon' and active = true order by name")
+		if err != nil {
+			return samples
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var n string
+			if err := rows.Scan(&n); err != nil {
+				continue
+			}
+			up := 0.0
+			if _, ok := cache.Daemon.Get(n); ok {
+				up = 1
+			}
+			samples = append(samples, metrics.Sample{Labels: []string{n}, Value: up})
+		}
+		return samples
+	}, "daemon")
+
+	// 进程资源
+	metrics.NewCounterFunc("process_cpu_seconds_total", "Total user and system CPU time spent in seconds.", func() []metrics.Sample {
+		if self == nil {
+			return nil
+		}
+		t, err := self.Times()
+		if err != nil {
+			return nil
+		}
+		return []metrics.Sample{{Value: t.User + t.System}}
+	})
+	metrics.NewGaugeFunc("process_resident_memory_bytes", "Resident memory size in bytes.", func() []metrics.Sample {
+		if self == nil {
+			return nil
+		}
+		m, err := self.MemoryInfo()
+		if err != nil {
+			return nil
+		}
+		return []metrics.Sample{{Value: float64(m.RSS)}}
+	})
+	metrics.NewGaugeFunc("process_virtual_memory_bytes", "Virtual memory size in bytes.", func() []metrics.Sample {
+		if self == nil {
+			return nil
+		}
+		m, err := self.MemoryInfo()
+		if err != nil {
+			return nil
+		}
+		return []metrics.Sample{{Value: float64(m.VMS)}}
+	})
+	metrics.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(runtime.NumGoroutine())}}
+	})
+}
+
 func RunMonitor() {
-	p, _ := process.NewProcess(int32(os.Getppid()))
+	// 仅在终端中打印，在 systemd 或 Docker 等环境下，应通过 /metrics 采集
+	if info, err := os.Stdout.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 || self == nil {
+		return
+	}
+
 	ticker := time.NewTicker(time.Millisecond * 1000)
 	for range ticker.C {
-		c, _ := p.CPUPercent()
-		m, _ := p.MemoryInfo()
+		c, _ := self.CPUPercent()
+		m, err := self.MemoryInfo()
+		if err != nil {
+			continue
+		}
 		fmt.Printf("\rcpu: %.2f%%, memory: %.2fmb, vm: %d/%d"+" ", // 结尾预留一个空格防止刷新过程中因字符串变短导致上一次打印的文本在结尾出溢出
 			c,
 			float32(m.RSS)/1024/1024,
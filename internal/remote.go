@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"cube/internal/cache"
+	"cube/internal/config"
+)
+
+// RemoteModuleMaxSize 远程模块的最大字节数
+const RemoteModuleMaxSize = 16 << 20
+
+var remoteClient = &http.Client{Timeout: 30 * time.Second}
+
+// Sha256 计算内容的 sha256 摘要，以十六进制字符串表示
+func Sha256(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// FetchRemoteModule 下载远程模块，仅接受 200 状态码的响应
+func FetchRemoteModule(url string) (string, error) {
+	resp, err := remoteClient.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch %s: %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, RemoteModuleMaxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(body) > RemoteModuleMaxSize {
+		return "", fmt.Errorf("failed to fetch %s: larger than %d bytes", url, RemoteModuleMaxSize)
+	}
+	return string(body), nil
+}
+
+// SaveRemoteModule 保存远程模块的内容，expected 不为空时内容的摘要必须与其一致
+// 已锁定的模块只有在指定了与新内容一致的摘要时才允许变更，即锁定到新的版本
+func SaveRemoteModule(url string, content string, expected string) (string, error) {
+	hash := Sha256(content)
+	if expected != "" && expected != hash {
+		return "", fmt.Errorf("integrity check failed for %s: expected sha256 %s, got %s", url, expected, hash)
+	}
+
+	var (
+		current string
+		pinned  bool
+	)
+	err := Db.QueryRow("select sha256, pinned from remote_module where url = ?", url).Scan(&current, &pinned)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	if pinned && current != hash && expected == "" {
+		return "", fmt.Errorf("%s is pinned to sha256 %s, got %s", url, current, hash)
+	}
+
+	if _, err := Db.Exec("insert into remote_module (url, content, sha256) values (?, ?, ?) on conflict (url) do update set content = excluded.content, sha256 = excluded.sha256, fetched_date = datetime('now', 'localtime')", url, content, hash); err != nil {
+		return "", err
+	}
+	cache.Module.Remove(url)
+
+	return hash, nil
+}
+
+// 加载远程模块：优先使用数据库中保存的内容并校验摘要，不存在时下载并保存，离线模式下不下载
+func loadRemoteModule(url string) (string, error) {
+	var content, hash string
+	err := Db.QueryRow("select content, sha256 from remote_module where url = ?", url).Scan(&content, &hash)
+	if err == nil {
+		if Sha256(content) != hash {
+			return "", fmt.Errorf("integrity check failed for %s: expected sha256 %s, got %s", url, hash, Sha256(content))
+		}
+		return content, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", err
+	}
+
+	if config.RemoteOffline {
+		return "", errors.New("remote module is not stored and downloading is disabled by -remote-offline: " + url)
+	}
+	if content, err = FetchRemoteModule(url); err != nil {
+		return "", err
+	}
+	if _, err := Db.Exec("insert or ignore into remote_module (url, content, sha256) values (?, ?, ?)", url, content, Sha256(content)); err != nil { // 并发加载时，以先保存的内容为准
+		return "", err
+	}
+	return content, nil
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cube/internal/util"
+
+	"github.com/dop251/goja"
+	"github.com/dop251/goja/parser"
+)
+
+// RunScript 在单独的 worker 中执行脚本，用于数据迁移等一次性的维护任务，target 为脚本文件的路径或已启用的模块名称（如 lib/migrate）
+// 与 javascript 模块一致，.js 文件须使用 CommonJS 规范，如 exports.default = function () {}
+// 脚本的默认导出为方法时以 args 为参数调用并返回其结果（Promise 将等待完成），否则返回模块的导出
+// 脚本文件中的相对路径 require 相对于根目录解析，如 "./lib/date" 对应数据库中的模块 lib/date
+func RunScript(target string, args []string) (interface{}, error) {
+	worker := NewWorker(NewProgram(), 0)
+
+	value, err := worker.EventLoop().Run(func() (goja.Value, error) {
+		var (
+			exports goja.Value
+			err     error
+		)
+		if info, serr := os.Stat(target); serr == nil && !info.IsDir() {
+			exports, err = worker.runFile(target)
+		} else {
+			exports, err = worker.requireModule("", "./"+strings.TrimPrefix(target, "./"))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		obj, ok := exports.(*goja.Object)
+		if !ok {
+			return exports, nil
+		}
+		function, ok := goja.AssertFunction(obj.Get("default"))
+		if !ok {
+			return exports, nil
+		}
+		params := make([]goja.Value, len(args))
+		for i, arg := range args {
+			params[i] = worker.runtime.ToValue(arg)
+		}
+		return function(obj, params...)
+	})
+	if worker.err != nil {
+		return nil, worker.err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return util.ExportGojaValue(value)
+}
+
+// 编译并运行脚本文件，语言由扩展名决定，返回模块的导出
+func (w *Worker) runFile(file string) (goja.Value, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(file)
+	lang := "javascript"
+	for l, ext := range sourceExts {
+		if ext == filepath.Ext(file) {
+			lang = l
+		}
+	}
+	if lang != "javascript" && !util.IsTypeScript(lang) {
+		return nil, errors.New(base + " is not a script")
+	}
+
+	src, sourceMap, err := CompileSource(strings.TrimSuffix(base, filepath.Ext(base)), "module", lang, string(data), "")
+	if err != nil {
+		return nil, err
+	}
+	if lang == "javascript" {
+		src = string(data)
+	}
+	parsed, err := goja.Parse(base, moduleWrapper+src+"\n})", parser.WithSourceMapLoader(func(p string) ([]byte, error) {
+		if sourceMap == "" {
+			return nil, nil
+		}
+		return []byte(sourceMap), nil
+	}))
+	if err != nil {
+		return nil, err
+	}
+	program, err := goja.CompileAST(parsed, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// 以根目录下的模块运行，不写入模块缓存
+	id := "./" + base
+	exports := w.runtime.NewObject()
+	module := w.runtime.NewObject()
+	module.Set("id", id)
+	module.Set("exports", exports)
+	module.Set("loaded", false)
+	w.modules.Set(id, module)
+	if err := w.runModule(program, id, exports, module); err != nil {
+		return nil, err
+	}
+	module.Set("loaded", true)
+	return module.Get("exports"), nil
+}
@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"cube/internal/model"
+)
+
+const (
+	searchMaxLines   = 100 // 每个源码最多返回的匹配行数
+	searchMaxSnippet = 240 // 行的内容超过该长度（字节）时截取片段
+)
+
+// SearchPattern 将查询条件转换为正则表达式，isRegexp 为 false 时按字面量匹配，caseSensitive 为 false 时忽略大小写
+func SearchPattern(query string, isRegexp bool, caseSensitive bool) (*regexp.Regexp, error) {
+	if query == "" {
+		return nil, errors.New("query is required")
+	}
+	if !isRegexp {
+		query = regexp.QuoteMeta(query)
+	}
+	if !caseSensitive {
+		query = "(?i)" + query
+	}
+	pattern, err := regexp.Compile(query)
+	if err != nil {
+		return nil, err
+	}
+	if pattern.MatchString("") { // 如 a*，将匹配每一个位置
+		return nil, errors.New("query must not match empty string")
+	}
+	return pattern, nil
+}
+
+// FindSources 查询内容与 pattern 匹配的源码，name 和 type 为 like 条件
+// 按字面量查询时通过全文索引筛选候选的源码（like 在 trigram 分词下可使用索引，且忽略 ASCII 字符的大小写），再使用 pattern 校验
+func FindSources(pattern *regexp.Regexp, literal string, name string, stype string) ([]model.Source, error) {
+	// like 仅忽略 ASCII 字符的大小写，忽略大小写查询非 ASCII 字符（如 É 与 é）时不使用索引筛选，以免遗漏
+	if strings.HasPrefix(pattern.String(), "(?i)") && !isASCII(literal) {
+		literal = ""
+	}
+	query, params := "select s.rowid, s.name, s.type, s.lang, s.content, s.active from source s where s.name like ? and s.type like ?", []interface{}{name, stype}
+	if literal != "" {
+		// 查询中的 % 和 _ 作为通配符时，筛选的结果只会更多，因此无需转义
+		query = "select s.rowid, s.name, s.type, s.lang, s.content, s.active from source_fts f join source s on s.name = f.name and s.type = f.type where f.content like ? and s.name like ? and s.type like ?"
+		params = append([]interface{}{"%" + literal + "%"}, params...)
+	}
+	rows, err := Db.Query(query+" order by s.type, s.name", params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := make([]model.Source, 0)
+	for rows.Next() {
+		var source model.Source
+		if err := rows.Scan(&source.Id, &source.Name, &source.Type, &source.Lang, &source.Content, &source.Active); err != nil {
+			return nil, err
+		}
+		if pattern.MatchString(source.Content) {
+			sources = append(sources, source)
+		}
+	}
+	return sources, rows.Err()
+}
+
+// SearchLines 查找内容中与 pattern 匹配的行，返回匹配的行（最多 searchMaxLines 行）和匹配的总次数
+// 跨行的匹配仅在起始行中标记至行尾
+func SearchLines(content string, pattern *regexp.Regexp) ([]model.SearchLine, int) {
+	matches := pattern.FindAllStringIndex(content, -1)
+	lines := make([]model.SearchLine, 0)
+
+	line, lineStart := 1, 0 // 当前的行号及其起始位置
+	for i := 0; i < len(matches) && len(lines) < searchMaxLines; {
+		// 定位匹配所在的行
+		for {
+			n := strings.IndexByte(content[lineStart:], '\n')
+			if n < 0 || lineStart+n >= matches[i][0] {
+				break
+			}
+			line, lineStart = line+1, lineStart+n+1
+		}
+		lineEnd := len(content)
+		if n := strings.IndexByte(content[lineStart:], '\n'); n >= 0 {
+			lineEnd = lineStart + n
+		}
+		text := strings.TrimSuffix(content[lineStart:lineEnd], "\r")
+
+		// 收集同一行中的匹配
+		ranges := make([][2]int, 0)
+		for ; i < len(matches) && matches[i][0] <= lineEnd; i++ {
+			ranges = append(ranges, [2]int{min(matches[i][0]-lineStart, len(text)), min(matches[i][1]-lineStart, len(text))})
+		}
+		lines = append(lines, snippet(line, text, ranges))
+	}
+	return lines, len(matches)
+}
+
+// 截取过长的行，并将匹配的位置由字节转换为 UTF-16 编码单元
+func snippet(line int, text string, ranges [][2]int) model.SearchLine {
+	start, end := 0, len(text)
+	if len(text) > searchMaxSnippet {
+		start = max(ranges[0][0]-searchMaxSnippet/3, 0)
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start--
+		}
+		end = min(start+searchMaxSnippet, len(text))
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+	}
+
+	s := model.SearchLine{Line: line, Column: utf16Len(text[:ranges[0][0]]) + 1, Text: text[start:end], Ranges: make([][2]int, 0, len(ranges))}
+	for _, r := range ranges {
+		if r[0] >= end {
+			break
+		}
+		s.Ranges = append(s.Ranges, [2]int{utf16Len(text[start:r[0]]), utf16Len(text[start:min(r[1], end)])})
+	}
+	return s
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
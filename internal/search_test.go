@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"cube/internal/model"
+)
+
+func TestSearchLines(t *testing.T) {
+	tests := []struct {
+		content  string
+		pattern  string
+		expected []model.SearchLine
+		count    int
+	}{
+		{"foo\nbar foo foo\n", "foo", []model.SearchLine{
+			{Line: 1, Column: 1, Text: "foo", Ranges: [][2]int{{0, 3}}},
+			{Line: 2, Column: 5, Text: "bar foo foo", Ranges: [][2]int{{4, 7}, {8, 11}}},
+		}, 3},
+		{"a\r\nb foo\r\n", "foo", []model.SearchLine{
+			{Line: 2, Column: 3, Text: "b foo", Ranges: [][2]int{{2, 5}}},
+		}, 1},
+		// 跨行的匹配仅在起始行中标记至行尾
+		{"xa\nyb\nab", `(?s)a.*?b`, []model.SearchLine{
+			{Line: 1, Column: 2, Text: "xa", Ranges: [][2]int{{1, 2}}},
+			{Line: 3, Column: 1, Text: "ab", Ranges: [][2]int{{0, 2}}},
+		}, 2},
+		{"a\n\nb", `a\n\nb`, []model.SearchLine{
+			{Line: 1, Column: 1, Text: "a", Ranges: [][2]int{{0, 1}}},
+		}, 1},
+		// 位置按 UTF-16 编码单元计算，辅助平面的字符占两个单元
+		{"😀a😀b", "b", []model.SearchLine{
+			{Line: 1, Column: 6, Text: "😀a😀b", Ranges: [][2]int{{5, 6}}},
+		}, 1},
+		{"😀a😀b", "😀", []model.SearchLine{
+			{Line: 1, Column: 1, Text: "😀a😀b", Ranges: [][2]int{{0, 2}, {3, 5}}},
+		}, 2},
+		{"中文 é", "(?i)É", []model.SearchLine{
+			{Line: 1, Column: 4, Text: "中文 é", Ranges: [][2]int{{3, 4}}},
+		}, 1},
+		// 超过 240 字节的行截取首个匹配附近的片段，片段的起止位置在字符的边界上
+		{strings.Repeat("x", 300) + "foo" + strings.Repeat("y", 300), "foo", []model.SearchLine{
+			{Line: 1, Column: 301, Text: strings.Repeat("x", 80) + "foo" + strings.Repeat("y", 157), Ranges: [][2]int{{80, 83}}},
+		}, 1},
+		{strings.Repeat("中", 100) + "foo", "foo", []model.SearchLine{
+			{Line: 1, Column: 101, Text: strings.Repeat("中", 27) + "foo", Ranges: [][2]int{{27, 30}}},
+		}, 1},
+		{"fo" + strings.Repeat("中", 100), "fo", []model.SearchLine{
+			{Line: 1, Column: 1, Text: "fo" + strings.Repeat("中", 80), Ranges: [][2]int{{0, 2}}},
+		}, 1},
+		{"foo" + strings.Repeat("x", 300) + "foo", "foo", []model.SearchLine{
+			{Line: 1, Column: 1, Text: "foo" + strings.Repeat("x", 237), Ranges: [][2]int{{0, 3}}},
+		}, 2},
+		{"x" + strings.Repeat("😀", 100), "😀", []model.SearchLine{
+			{Line: 1, Column: 2, Text: "x" + strings.Repeat("😀", 60), Ranges: func() [][2]int {
+				ranges := make([][2]int, 0, 60)
+				for i := 0; i < 60; i++ {
+					ranges = append(ranges, [2]int{1 + i*2, 3 + i*2})
+				}
+				return ranges
+			}()},
+		}, 100},
+	}
+	for _, test := range tests {
+		lines, count := SearchLines(test.content, regexp.MustCompile(test.pattern))
+		if !reflect.DeepEqual(lines, test.expected) || count != test.count {
+			t.Errorf("SearchLines(%q, %q) = %v, %d, expected %v, %d", test.content, test.pattern, lines, count, test.expected, test.count)
+		}
+	}
+
+	// 最多返回 searchMaxLines 行，但统计全部的匹配
+	lines, count := SearchLines(strings.Repeat("a\n", searchMaxLines+50), regexp.MustCompile("a"))
+	if len(lines) != searchMaxLines || count != searchMaxLines+50 || lines[searchMaxLines-1].Line != searchMaxLines {
+		t.Errorf("SearchLines of %d lines returned %d lines, %d matches", searchMaxLines+50, len(lines), count)
+	}
+}
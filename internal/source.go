@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"cube/internal/cache"
+	"cube/internal/model"
+	"cube/internal/util"
+)
+
+// CheckSourceName 校验源码的名称，模块的名称可包含目录，如 lib/date、node_modules/lodash/index
+// node_modules/ 下的模块由 npm 包导入，名称还可包含 npm 包名和文件名中常见的 .、-、~ 和 @scope，如 node_modules/@babel/runtime/helpers/extends、node_modules/lodash.merge/index
+func CheckSourceName(name string, stype string) error {
+	if stype == "module" && strings.HasPrefix(name, "node_modules/") {
+		if ok, _ := regexp.MatchString(`^node_modules/(@[\w~-][\w.~-]{0,63}/)?([\w~-][\w.~-]{0,63}/){0,15}[\w~-][\w.~-]{0,63}$`, name); !ok {
+			return errors.New("name must be a path under node_modules/, each part matches /[A-Za-z0-9_~-][A-Za-z0-9_.~-]{0,63}/")
+		}
+		return nil
+	}
+	if stype == "module" {
+		if ok, _ := regexp.MatchString("^(node_modules/)?(\\w{1,32}/){0,7}\\w{2,32}$", name); !ok {
+			return errors.New("name is required, it must be a string that matches /(node_modules/)?([A-Za-z0-9_]{1,32}/){0,7}[A-Za-z0-9_]{2,32}/")
+		}
+		if ok, _ := regexp.MatchString("^(controller|daemon|crontab)/", name); ok { // 与 controller、daemon、crontab 的模块 id 冲突
+			return errors.New("name must not start with controller/, daemon/ or crontab/")
+		}
+		return nil
+	}
+	if ok, _ := regexp.MatchString("^\\w{2,32}$", name); !ok {
+		return errors.New("name is required, it must be a string that matches /[A-Za-z0-9_]{2,32}/")
+	}
+	return nil
+}
+
+// CheckSourceSave 校验通过命令行或目录同步保存的源码，本地数据库和运行中的服务执行相同的规则，current 为修改前的源码，新增时为 nil
+func CheckSourceSave(source model.Source, current *model.Source) error {
+	if current == nil {
+		if err := CheckSourceType(source.Type); err != nil {
+			return err
+		}
+		if err := CheckSourceName(source.Name, source.Type); err != nil {
+			return err
+		}
+	} else if source.Lang != current.Lang { // 服务端不支持修改已有源码的语言
+		return errors.New("the language of an existing source can not be changed")
+	}
+	return CheckSourceOptions(source)
+}
+
+// CheckSourceType 校验源码的类型
+func CheckSourceType(stype string) error {
+	if ok, _ := regexp.MatchString("^(module|controller|daemon|crontab|template|resource)$", stype); !ok {
+		return errors.New("type must be module, controller, daemon, crontab, template or resource")
+	}
+	return nil
+}
+
+// CheckSourceUrl 校验 controller 和 resource 的 url 不能重复，activeOnly 为 true 时仅与启用的源码比较
+func CheckSourceUrl(name string, stype string, url string, activeOnly bool) error {
+	if stype != "controller" && stype != "resource" {
+		return nil
+	}
+	var count int
+	if err := Db.QueryRow("select count(1) from source where type = ? and url = ? and (active = true or ?) and name != ?", stype, url, !activeOnly, name).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("url already exists")
+	}
+	return nil
+}
+
+// CheckSourceOptions 按源码类型校验 crontab 的 cron 表达式和执行配置，以及 daemon 的重启策略
+// 无效的 cron 表达式在调度时才会暴露，因此须在保存前校验
+func CheckSourceOptions(source model.Source) error {
+	switch source.Type {
+	case "crontab":
+		if _, err := util.ParseCron(source.Cron); err != nil {
+			return err
+		}
+		return CheckCrontabOptions(source.Timeout, source.Overlap, source.Retries)
+	case "daemon":
+		return CheckDaemonRestart(source.Restart)
+	}
+	return nil
+}
+
+// CheckCrontabOptions 校验定时任务的执行配置
+func CheckCrontabOptions(timeout int, overlap string, retries int) error {
+	if timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if overlap != "allow" && overlap != "skip" && overlap != "queue" {
+		return errors.New("overlap must be allow, skip or queue")
+	}
+	if retries < 0 || retries > 10 {
+		return errors.New("retries must be between 0 and 10")
+	}
+	return nil
+}
+
+// CheckDaemonRestart 校验 daemon 的重启策略
+func CheckDaemonRestart(restart string) error {
+	if restart != "never" && restart != "on-failure" && restart != "always" {
+		return errors.New("restart must be never, on-failure or always")
+	}
+	return nil
+}
+
+// CompileSource 如果未提供编译后的代码（例如通过 IDE 以外的工具提交源码），则在服务端编译 TypeScript 源码
+// 编译后代码中内联的源映射将被拆分出来单独存储，并按模块加载时的方式校验语法，以免语法错误在首次请求时才暴露
+func CompileSource(name string, stype string, lang string, content string, compiled string) (string, string, error) {
+	if compiled == "" && content != "" && util.IsTypeScript(lang) {
+		var err error
+		if compiled, err = util.Transpile(name, lang, content); err != nil {
+			return "", "", err
+		}
+	}
+	compiled, sourceMap := util.SplitSourceMap(name, compiled)
+	if err := CheckSource(name, stype, lang, content, compiled, sourceMap); err != nil {
+		return "", "", err
+	}
+	return compiled, sourceMap, nil
+}
+
+// PreviewController 查找 url 与路径匹配的未启用的 controller，用于预览尚未启用的 controller 的草稿
+func PreviewController(path string) (*model.Source, map[string]string) {
+	rows, err := Db.Query("select name, method, url from source where type = 'controller' and active = false order by rowid desc")
+	if err != nil {
+		return nil, nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		source := &model.Source{}
+		if err := rows.Scan(&source.Name, &source.Method, &source.Url); err != nil {
+			continue
+		}
+		if vars, ok := cache.MatchRoute(source.Url, path); ok {
+			return source, vars
+		}
+	}
+	return nil, nil
+}
+
+// RefreshSource 修改源码后刷新相关的缓存，并按需启停 crontab 和 daemon，status 为 daemon 的启停指令
+func RefreshSource(source model.Source, status interface{}) {
+	switch source.Type {
+	case "controller":
+		if source.Active {
+			// 更新路由
+			cache.Route.Set(source.Name, source.Url) // 更新路由
+		} else {
+			// 删除路由
+			cache.Route.Remove(source.Name)
+		}
+		cache.Controller.Remove(source.Name) // 删除缓存
+	case "crontab":
+		id, ok := cache.Crontab.Get(source.Name)
+		if !ok && source.Active {
+			RunCrontabs(source.Name) // 启动 crontab
+		}
+		if ok && !source.Active {
+			Crontab.Remove(id)
+			cache.Crontab.Remove(source.Name) // 删除缓存
+		}
+	case "daemon":
+		if source.Active {
+			if status == "true" {
+				RunDaemons(source.Name) // 启动，如果已在运行或等待重启，则忽略
+			}
+			if status == "false" {
+				StopDaemon(source.Name) // 停止，同时取消等待中的重启
+			}
+		}
+	}
+	if id := SourceModuleId(source.Name, source.Type); id != "" {
+		cache.Module.Remove(id)  // 删除缓存
+		cache.Preview.Remove(id) // 预览时同样加载未启用的源码，需一并删除
+	}
+}
@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"cube/internal/cache"
+	"cube/internal/log"
+	"cube/internal/model"
+	"cube/internal/util"
+)
+
+// ErrSourceNotExist 查询、修改或删除的源码不存在
+var ErrSourceNotExist = errors.New("source does not exist")
+
+// SourceStore 读写源码的位置，即本地的数据库或运行中的服务，用于命令行管理源码以及与目录同步源码
+type SourceStore interface {
+	// Sources 查询所有已发布的源码，包含源码内容，不包含编译后的代码和草稿
+	Sources() ([]model.Source, error)
+	// Get 查询已发布的源码，包含源码内容
+	Get(name string, stype string) (model.Source, error)
+	// Save 新增或修改源码，current 为修改前的源码，新增时为 nil，返回修改后的版本号
+	Save(source model.Source, current *model.Source) (util.Time, error)
+	// SetActive 启用或停用源码，force 为 false 时，仍被已启用的源码 require 的源码不能停用
+	SetActive(name string, stype string, active bool, force bool) error
+	// Delete 删除源码，force 为 false 时，仍被已启用的源码 require 的已启用源码不能删除
+	Delete(name string, stype string, force bool) error
+}
+
+// DbStore 直接读写当前目录下 cube.db 中的源码，Refresh 为 true 时在修改后刷新运行中服务的缓存（即与服务在同一进程中）
+type DbStore struct {
+	Refresh bool
+	User    string // 审计日志中记录的用户，如 "cli:root"
+}
+
+const sourceColumns = "name, type, lang, content, active, method, url, cron, tag, timeout, overlap, retries, restart, last_modified_date"
+
+func scanSource(row interface{ Scan(...interface{}) error }) (model.Source, error) {
+	var s model.Source
+	err := row.Scan(&s.Name, &s.Type, &s.Lang, &s.Content, &s.Active, &s.Method, &s.Url, &s.Cron, &s.Tag, &s.Timeout, &s.Overlap, &s.Retries, &s.Restart, &s.LastModifiedDate)
+	return s, err
+}
+
+func (s *DbStore) Sources() ([]model.Source, error) {
+	rows, err := Db.Query("select " + sourceColumns + " from source order by type, name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sources := make([]model.Source, 0)
+	for rows.Next() {
+		source, err := scanSource(rows)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
+
+func (s *DbStore) Get(name string, stype string) (model.Source, error) {
+	source, err := scanSource(Db.QueryRow("select "+sourceColumns+" from source where name = ? and type = ?", name, stype))
+	if err == sql.ErrNoRows {
+		return source, ErrSourceNotExist
+	}
+	return source, err
+}
+
+func (s *DbStore) Save(source model.Source, current *model.Source) (util.Time, error) {
+	var date util.Time
+	if source.Overlap == "" {
+		source.Overlap = "allow"
+	}
+	if source.Restart == "" {
+		source.Restart = "never"
+	}
+	if err := CheckSourceSave(source, current); err != nil {
+		return date, err
+	}
+	if current != nil && current.Active && !source.Active { // 与服务端一致，停用仍被 require 的源码须通过 SetActive 强制执行
+		if err := CheckDependents(source.Name, source.Type); err != nil {
+			return date, err
+		}
+	}
+	// 与服务端一致，新增时 url 不能与任何源码重复，修改时不能与启用的源码重复
+	if current == nil || source.Active {
+		if err := CheckSourceUrl(source.Name, source.Type, source.Url, current != nil); err != nil {
+			return date, err
+		}
+	}
+	if current == nil {
+		var count int
+		if Db.QueryRow("select count(1) from source where name = ? and type = ?", source.Name, source.Type).Scan(&count); count > 0 {
+			return date, errors.New("source already exists")
+		}
+	}
+	compiled, sourceMap, err := CompileSource(source.Name, source.Type, source.Lang, source.Content, "")
+	if err != nil {
+		return date, err
+	}
+
+	if current != nil {
+		// 仅在版本号未变化时修改，以免覆盖在此期间通过 IDE 保存的修改
+		res, err := Db.Exec("update source set lang = ?, content = ?, compiled = ?, source_map = ?, active = ?, method = ?, url = ?, cron = ?, timeout = ?, overlap = ?, retries = ?, restart = ?, tag = ?, last_modified_date = datetime('now', 'localtime') where name = ? and type = ? and last_modified_date = ?", source.Lang, source.Content, compiled, sourceMap, source.Active, source.Method, source.Url, source.Cron, source.Timeout, source.Overlap, source.Retries, source.Restart, source.Tag, source.Name, source.Type, current.LastModifiedDate.String())
+		if err != nil {
+			return date, err
+		}
+		if count, _ := res.RowsAffected(); count == 0 {
+			return date, errors.New("source version conflict")
+		}
+	} else if _, err := Db.Exec("insert into source (name, type, lang, content, compiled, source_map, active, method, url, cron, timeout, overlap, retries, restart, tag, last_modified_date) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, datetime('now', 'localtime'))", source.Name, source.Type, source.Lang, source.Content, compiled, sourceMap, source.Active, source.Method, source.Url, source.Cron, source.Timeout, source.Overlap, source.Retries, source.Restart, source.Tag); err != nil {
+		return date, err
+	}
+	if err := UpdateDependencies(source.Name, source.Type); err != nil {
+		return date, err
+	}
+	if err := Db.QueryRow("select last_modified_date from source where name = ? and type = ?", source.Name, source.Type).Scan(&date); err != nil {
+		return date, err
+	}
+	if current == nil {
+		Audit(model.AuditLog{User: s.User, Action: "create", Name: source.Name, Type: source.Type})
+	} else {
+		Audit(model.AuditLog{User: s.User, Action: "edit", Name: source.Name, Type: source.Type})
+		if source.Active != current.Active {
+			Audit(model.AuditLog{User: s.User, Action: map[bool]string{true: "activate", false: "deactivate"}[source.Active], Name: source.Name, Type: source.Type})
+		}
+	}
+
+	if s.Refresh {
+		var status interface{}
+		if source.Type == "daemon" && (current == nil || current.Active != source.Active) {
+			status = strconv.FormatBool(source.Active) // 随启用状态启停 daemon
+		}
+		s.refresh(source, status)
+	}
+	return date, nil
+}
+
+func (s *DbStore) SetActive(name string, stype string, active bool, force bool) error {
+	source, err := s.Get(name, stype)
+	if err != nil {
+		return err
+	}
+	if !active && source.Active && !force {
+		if err := CheckDependents(name, stype); err != nil {
+			return err
+		}
+	}
+	if active {
+		if err := CheckSourceUrl(name, stype, source.Url, true); err != nil {
+			return err
+		}
+		if err := CheckSourceOptions(source); err != nil { // 启用前写入的无效配置将导致调度失败
+			return err
+		}
+	}
+	if _, err := Db.Exec("update source set active = ? where name = ? and type = ?", active, name, stype); err != nil {
+		return err
+	}
+	Audit(model.AuditLog{User: s.User, Action: map[bool]string{true: "activate", false: "deactivate"}[active], Name: name, Type: stype})
+	if s.Refresh {
+		source.Active = active
+		s.refresh(source, strconv.FormatBool(active))
+	}
+	return nil
+}
+
+func (s *DbStore) Delete(name string, stype string, force bool) error {
+	if !force {
+		var active bool
+		if Db.QueryRow("select active from source where name = ? and type = ?", name, stype).Scan(&active); active {
+			if err := CheckDependents(name, stype); err != nil {
+				return err
+			}
+		}
+	}
+	res, err := Db.Exec("delete from source where name = ? and type = ?", name, stype)
+	if err != nil {
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return ErrSourceNotExist
+	}
+	Audit(model.AuditLog{User: s.User, Action: "delete", Name: name, Type: stype})
+	if s.Refresh {
+		s.refresh(model.Source{Name: name, Type: stype}, "false")
+	}
+	if err := log.RemoveBuffer(stype, name); err != nil { // 源码已删除，不影响删除的结果
+		log.Error(log.Fields{Worker: -1, Source: name, Type: stype}, "failed to remove the buffered logs:", err)
+	}
+	return nil
+}
+
+// 刷新缓存，cron 表达式可能已改变，需要重新调度
+func (s *DbStore) refresh(source model.Source, status interface{}) {
+	if id, ok := cache.Crontab.Get(source.Name); ok && source.Type == "crontab" {
+		Crontab.Remove(id)
+		cache.Crontab.Remove(source.Name)
+	}
+	RefreshSource(source, status)
+}
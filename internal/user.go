@@ -0,0 +1,243 @@
+package internal
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"cube/internal/config"
+	"cube/internal/log"
+	"cube/internal/model"
+)
+
+// Roles 账号的角色，权限依次递增：
+//   - viewer：只读，可查看源码、日志、历史版本等
+//   - developer：可新增源码，修改停用的源码，保存草稿，执行脚本和预览草稿，但不能修改 daemon 和 crontab
+//   - publisher：可修改任意类型的源码，启停、发布、删除和回滚源码，管理远程模块和 npm 包
+//   - admin：可批量导入源码，管理账号和查看审计日志
+var Roles = []string{"viewer", "developer", "publisher", "admin"}
+
+// TokenScopes API 令牌的权限范围，与所有者的角色共同限制令牌可执行的操作：
+//   - read：GET 请求，如查询源码、日志、历史版本
+//   - write：新增、修改、启停、删除源码等其余请求，须所有者具备相应的角色
+//   - eval：执行脚本和预览草稿
+//   - admin：批量导入源码，管理账号、令牌和查看审计日志，须所有者具备 admin 角色
+var TokenScopes = []string{"read", "write", "eval", "admin"}
+
+const passwordIterations = 210000
+
+// 账号不存在时用于校验的摘要，与真实账号执行相同的计算，以免响应时间暴露账号是否存在
+var dummyPasswordHash = "pbkdf2-sha256$" + strconv.Itoa(passwordIterations) + "$" + base64.RawStdEncoding.EncodeToString(make([]byte, 16)) + "$" + base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+
+// HasRole 判断角色是否具备 min 角色的权限
+func HasRole(role string, min string) bool {
+	i := slices.Index(Roles, role)
+	return i >= 0 && i >= slices.Index(Roles, min)
+}
+
+// CanWriteSource 判断角色是否可以修改指定类型的源码，daemon 和 crontab 在后台常驻或定时执行，需要 publisher 角色
+func CanWriteSource(role string, stype string) bool {
+	if stype == "daemon" || stype == "crontab" {
+		return HasRole(role, "publisher")
+	}
+	return HasRole(role, "developer")
+}
+
+// HashPassword 计算口令的 PBKDF2-SHA256 摘要
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, 32)
+	if err != nil {
+		return "", err
+	}
+	return "pbkdf2-sha256$" + strconv.Itoa(passwordIterations) + "$" + base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(key), nil
+}
+
+// 校验口令与摘要是否匹配
+func checkPassword(hash string, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(expected))
+	return err == nil && subtle.ConstantTimeCompare(key, expected) == 1
+}
+
+// 令牌的摘要，数据库中仅保存摘要，以免数据库泄露后令牌被直接使用
+func tokenDigest(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// 是否存在任一启用的账号，缓存于内存中以免每次请求均查询数据库，账号变更后须调用 RefreshAuthEnabled 更新
+var authEnabled atomic.Bool
+
+// AuthEnabled 存在任一启用的账号时，IDE 和管理接口须登录后使用
+func AuthEnabled() bool {
+	return authEnabled.Load()
+}
+
+// RefreshAuthEnabled 新增、修改或删除账号后，重新查询是否存在启用的账号
+func RefreshAuthEnabled() {
+	var enabled bool
+	if err := Db.QueryRow("select exists(select 1 from user where active = true)").Scan(&enabled); err != nil {
+		enabled = true // 查询失败时拒绝访问
+	}
+	authEnabled.Store(enabled)
+}
+
+// EnsureAdmin 按启动参数 -a 创建 admin 账号，账号已存在时重置其口令并启用
+func EnsureAdmin(userpass string) error {
+	name, password, ok := strings.Cut(userpass, ":")
+	if !ok || name == "" || password == "" {
+		return errors.New("-a must be <username:password>")
+	}
+	var (
+		hash, role string
+		active     bool
+	)
+	if err := Db.QueryRow("select password, role, active from user where name = ?", name).Scan(&hash, &role, &active); err == nil && role == "admin" && active && checkPassword(hash, password) {
+		return nil // 未变更时保留已登录的会话
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if _, err := Db.Exec("insert into user (name, password, role) values (?, ?, 'admin') on conflict (name) do update set password = excluded.password, role = 'admin', active = true", name, hash); err != nil {
+		return err
+	}
+	authEnabled.Store(true)
+	return DeleteSessions(name) // 口令已重置，原有的会话失效
+}
+
+// VerifyPassword 校验账号和口令，账号不存在或已停用时同样返回异常
+func VerifyPassword(name string, password string) (model.User, error) {
+	var (
+		user model.User
+		hash string
+	)
+	err := Db.QueryRow("select name, password, role, active from user where name = ?", name).Scan(&user.Name, &hash, &user.Role, &user.Active)
+	if err != nil {
+		hash = dummyPasswordHash
+	}
+	if !checkPassword(hash, password) || err != nil || !user.Active {
+		time.Sleep(500 * time.Millisecond) // 延缓暴力破解
+		return user, errors.New("invalid name or password")
+	}
+	return user, nil
+}
+
+// Login 校验账号和口令，成功后创建会话并返回会话令牌
+func Login(name string, password string) (string, model.User, error) {
+	user, err := VerifyPassword(name, password)
+	if err != nil {
+		return "", user, err
+	}
+
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	expires := time.Now().Add(time.Duration(config.SessionHours) * time.Hour).Format("2006-01-02 15:04:05")
+	if _, err := Db.Exec("insert into user_session (token, user, expires_date) values (?, ?, ?)", tokenDigest(token), user.Name, expires); err != nil {
+		return "", user, err
+	}
+	Db.Exec("update user set last_login_date = datetime('now', 'localtime') where name = ?", user.Name)
+	Db.Exec("delete from user_session where expires_date < datetime('now', 'localtime')") // 清理过期的会话
+	return token, user, nil
+}
+
+// VerifySession 根据会话令牌获取当前用户，会话不存在、已过期或账号已停用时返回异常
+func VerifySession(token string) (*model.User, error) {
+	if token == "" {
+		return nil, errors.New("login required")
+	}
+	var user model.User
+	err := Db.QueryRow("select u.name, u.role, u.active from user_session s join user u on u.name = s.user where s.token = ? and s.expires_date > datetime('now', 'localtime') and u.active = true", tokenDigest(token)).Scan(&user.Name, &user.Role, &user.Active)
+	if err != nil {
+		return nil, errors.New("login required")
+	}
+	Db.Exec("update user_session set last_used_date = datetime('now', 'localtime') where token = ?", tokenDigest(token))
+	return &user, nil
+}
+
+// Logout 删除会话
+func Logout(token string) error {
+	_, err := Db.Exec("delete from user_session where token = ?", tokenDigest(token))
+	return err
+}
+
+// DeleteSessions 删除账号的所有会话，用于修改口令、停用或删除账号后
+func DeleteSessions(name string) error {
+	_, err := Db.Exec("delete from user_session where user = ?", name)
+	return err
+}
+
+// CreateToken 为账号创建 API 令牌，days 为有效天数，0 表示永不过期，返回的令牌仅在创建时可见
+func CreateToken(user string, name string, scopes []string, days int) (string, error) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	token := "cube_" + hex.EncodeToString(b) // 前缀便于在代码仓库中扫描泄露的令牌
+	var expires interface{}
+	if days > 0 {
+		expires = time.Now().AddDate(0, 0, days).Format("2006-01-02 15:04:05")
+	}
+	if _, err := Db.Exec("insert into api_token (name, token, user, scopes, expires_date) values (?, ?, ?, ?, ?)", name, tokenDigest(token), user, strings.Join(scopes, ","), expires); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			return "", errors.New("token name already exists")
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+// VerifyToken 根据 API 令牌获取所有者，令牌不存在、已过期或所有者已停用时返回异常
+func VerifyToken(token string) (*model.User, error) {
+	var (
+		user   model.User
+		scopes string
+	)
+	err := Db.QueryRow("select u.name, u.role, u.active, t.name, t.scopes from api_token t join user u on u.name = t.user where t.token = ? and (t.expires_date is null or t.expires_date > datetime('now', 'localtime')) and u.active = true", tokenDigest(token)).Scan(&user.Name, &user.Role, &user.Active, &user.Token, &scopes)
+	if err != nil {
+		return nil, errors.New("invalid or expired token")
+	}
+	user.Scopes = strings.Split(scopes, ",")
+	Db.Exec("update api_token set last_used_date = datetime('now', 'localtime') where token = ?", tokenDigest(token))
+	return &user, nil
+}
+
+// Audit 记录审计日志，User 为空表示未启用登录，Detail 超过 4 KB 时截断
+func Audit(entry model.AuditLog) {
+	if len(entry.Detail) > 4096 {
+		n := 4096
+		for n > 0 && !utf8.RuneStart(entry.Detail[n]) { // 在字符的边界截断
+			n--
+		}
+		entry.Detail = entry.Detail[:n] + "..."
+	}
+	if _, err := Db.Exec("insert into audit_log (user, token, action, name, type, detail, remote_addr) values (?, ?, ?, ?, ?, ?, ?)", entry.User, entry.Token, entry.Action, entry.Name, entry.Type, entry.Detail, entry.RemoteAddr); err != nil {
+		log.Error(log.Fields{Worker: -1, Source: entry.Name, Type: entry.Type}, "failed to write the audit log:", err)
+	}
+}
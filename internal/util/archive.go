@@ -0,0 +1,85 @@
+package util
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// ArchiveMaxSize 解压后的文件总字节数上限
+const ArchiveMaxSize = 64 << 20
+
+// ReadArchive 读取 tar.gz（如 npm pack 生成的 .tgz）、tar 或 zip 格式的压缩包，返回其中所有的文件，键为以 "/" 分隔的相对路径
+func ReadArchive(data []byte) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	total := 0
+	add := func(name string, r io.Reader) error {
+		name = path.Clean(strings.TrimPrefix(strings.ReplaceAll(name, "\\", "/"), "/"))
+		if name == "." || name == ".." || strings.HasPrefix(name, "../") { // 防止路径穿越
+			return nil
+		}
+		content, err := io.ReadAll(io.LimitReader(r, int64(ArchiveMaxSize-total+1)))
+		if err != nil {
+			return err
+		}
+		if total += len(content); total > ArchiveMaxSize {
+			return errors.New("archive is too large")
+		}
+		files[name] = content
+		return nil
+	}
+
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range reader.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = add(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.New("unsupported archive, expect .tgz, .tar or .zip: " + err.Error())
+		}
+		if header.Typeflag != tar.TypeReg { // 旧格式的 TypeRegA 已被 tar.Reader 转换为 TypeReg
+			continue
+		}
+		if err := add(header.Name, reader); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
@@ -1,7 +1,14 @@
 package util
 
-import "github.com/robfig/cron/v3"
+import (
+	_ "time/tzdata" // 内置时区数据，以便在未安装时区数据的系统（如 alpine）中使用 CRON_TZ
+
+	"github.com/robfig/cron/v3"
+)
+
+// CronParser 支持可选的秒字段，以及 CRON_TZ=Asia/Shanghai 形式的时区前缀
+var CronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
 
 func ParseCron(c string) (cron.Schedule, error) {
-	return cron.ParseStandard(c)
+	return CronParser.Parse(c)
 }
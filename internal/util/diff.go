@@ -0,0 +1,196 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnifiedDiff 按行比较两段文本，返回统一格式（unified format）的差异，context 为每处差异前后保留的上下文行数
+// 两段文本相同时返回空字符串
+func UnifiedDiff(a string, b string, fromName string, toName string, context int) string {
+	x, y := splitLines(a), splitLines(b)
+	ops := diffLines(x, y)
+
+	var sb strings.Builder
+	for i := 0; i < len(ops); {
+		// 跳过相同的行，找到下一处差异
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// 确定当前块的范围，间隔不超过 2 * context 行的差异合并为一块
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		for start < i && ops[start].kind != ' ' {
+			start++
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			n := end
+			for n < len(ops) && ops[n].kind == ' ' {
+				n++
+			}
+			if n == len(ops) || n-end > 2*context {
+				end += min(context, n-end)
+				break
+			}
+			end = n
+		}
+
+		if sb.Len() == 0 {
+			sb.WriteString("--- " + fromName + "\n+++ " + toName + "\n")
+		}
+		ax, ay, bx, by := ops[start].ax, 0, ops[start].bx, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				ay++
+			}
+			if op.kind != '-' {
+				by++
+			}
+		}
+		sb.WriteString(fmt.Sprintf("@@ -%s +%s @@\n", hunkRange(ax, ay), hunkRange(bx, by)))
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			sb.WriteByte('\n')
+		}
+		i = end
+	}
+	return sb.String()
+}
+
+type diffOp struct {
+	kind   byte // ' '、'-'、'+'
+	line   string
+	ax, bx int // 该行之前在两段文本中已处理的行数
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// 块的起始行号从 1 开始，块为空时为前一行的行号
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// diffMaxEdits 使用 Myers 算法比较时允许的最大编辑距离，超过后剩余部分按整体删除再新增处理，以限制内存的占用
+const diffMaxEdits = 2000
+
+// 按行计算两段文本的编辑脚本，先去除相同的前缀和后缀，再使用 Myers 算法计算最短编辑脚本
+func diffLines(a []string, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		ops = append(ops, diffOp{' ', a[prefix], prefix, prefix})
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	for _, op := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		op.ax, op.bx = op.ax+prefix, op.bx+prefix
+		ops = append(ops, op)
+	}
+
+	for i := suffix; i > 0; i-- {
+		ops = append(ops, diffOp{' ', a[len(a)-i], len(a) - i, len(b) - i})
+	}
+	return ops
+}
+
+func myers(a []string, b []string) []diffOp {
+	n, m := len(a), len(b)
+	max := n + m
+	v := make([]int, 2*max+4) // 对角线 k 对应的下标为 max + 1 + k
+	trace := make([][]int, 0) // trace[d] 为第 d 轮开始前各条对角线上到达的最远位置，仅保存 [-d-1, d+1] 范围内的对角线
+
+	found := false
+L:
+	for d := 0; d <= max && d <= diffMaxEdits; d++ {
+		trace = append(trace, append([]int(nil), v[max-d:max+d+3]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[max+k] < v[max+k+2]) {
+				x = v[max+k+2] // 向下移动，即插入 b 中的行
+			} else {
+				x = v[max+k] + 1 // 向右移动，即删除 a 中的行
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[max+k+1] = x
+			if x >= n && y >= m {
+				found = true
+				break L
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, n+m)
+	if !found { // 差异过大，整体删除再新增
+		for i := range a {
+			ops = append(ops, diffOp{'-', a[i], i, 0})
+		}
+		for i := range b {
+			ops = append(ops, diffOp{'+', b[i], n, i})
+		}
+		return ops
+	}
+
+	// 从终点回溯编辑路径
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		k := x - y
+		get := func(k int) int { // 读取第 d 轮开始前对角线 k 上到达的最远位置
+			return trace[d][k+d+1]
+		}
+		prevK := k - 1
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			ops = append(ops, diffOp{' ', a[x], x, y})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, diffOp{'+', b[y], x, y})
+		} else {
+			x--
+			ops = append(ops, diffOp{'-', a[x], x, y})
+		}
+	}
+
+	// 反转为正序
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
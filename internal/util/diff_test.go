@@ -0,0 +1,69 @@
+package util
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	b := "a\nb\nC\nd\ne\nf\ng\nh\ni\nj\nk\n"
+
+	if d := UnifiedDiff(a, a, "a", "b", 3); d != "" {
+		t.Fatalf("unexpected diff of equal texts: %q", d)
+	}
+
+	expected := strings.Join([]string{
+		"--- a",
+		"+++ b",
+		"@@ -1,6 +1,6 @@",
+		" a",
+		" b",
+		"-c",
+		"+C",
+		" d",
+		" e",
+		" f",
+		"@@ -8,3 +8,4 @@",
+		" h",
+		" i",
+		" j",
+		"+k",
+		"",
+	}, "\n")
+	if d := UnifiedDiff(a, b, "a", "b", 3); d != expected {
+		t.Fatalf("unexpected diff:\n%s", d)
+	}
+
+	if d := UnifiedDiff("", "x\n", "a", "b", 3); d != "--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n" {
+		t.Fatalf("unexpected diff of empty text:\n%s", d)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func() []string {
+		lines := make([]string, r.Intn(40))
+		for i := range lines {
+			lines[i] = string(rune('a' + r.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := random(), random()
+		var x, y []string
+		for _, op := range diffLines(a, b) {
+			if op.kind != '+' {
+				x = append(x, op.line)
+			}
+			if op.kind != '-' {
+				y = append(y, op.line)
+			}
+		}
+		if strings.Join(x, "\n") != strings.Join(a, "\n") || strings.Join(y, "\n") != strings.Join(b, "\n") {
+			t.Fatalf("diff does not reproduce the texts: %v, %v", a, b)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/evanw/esbuild/pkg/api"
+)
+
+// IsTypeScript 判断源码语言是否需要编译
+func IsTypeScript(lang string) bool {
+	return lang == "typescript" || lang == "tsx"
+}
+
+// Transpile 将 TypeScript 或 TSX 源码编译为 CommonJS 代码，去除类型声明，并内联源映射（包含源码），与 IDE 在浏览器中编译的结果等效
+func Transpile(name string, lang string, content string) (string, error) {
+	loader, ext := api.LoaderTS, ".ts"
+	if lang == "tsx" {
+		loader, ext = api.LoaderTSX, ".tsx"
+	}
+
+	result := api.Transform(content, api.TransformOptions{
+		Loader:     loader,
+		Format:     api.FormatCommonJS,
+		Target:     api.ES2017, // goja 不完全兼容 ES2018 及以上版本的部分语法，这些语法将被转换
+		Sourcemap:  api.SourceMapInline,
+		Sourcefile: name + ext,
+	})
+
+	if len(result.Errors) > 0 {
+		messages := make([]string, 0, len(result.Errors))
+		for _, m := range result.Errors {
+			if m.Location != nil { // 行号从 1 开始，列号从 0 开始
+				messages = append(messages, fmt.Sprintf("%s:%d:%d: %s", m.Location.File, m.Location.Line, m.Location.Column+1, m.Text))
+			} else {
+				messages = append(messages, m.Text)
+			}
+		}
+		return "", errors.New(strings.Join(messages, "\n"))
+	}
+
+	return string(result.Code), nil
+}
+
+// SplitSourceMap 从编译后的代码中拆分出内联的源映射，并将其替换为对 name.js.map 的引用，以便源映射单独存储，在加载源码时通过 parser.WithSourceMapLoader 提供
+// 如果代码中不包含内联的源映射，则原样返回
+func SplitSourceMap(name string, compiled string) (code string, sourceMap string) {
+	i := strings.LastIndex(compiled, "//# sourceMappingURL=data:application/json")
+	if i < 0 || (i > 0 && compiled[i-1] != '\n') {
+		return compiled, ""
+	}
+
+	line, rest, _ := strings.Cut(compiled[i:], "\n")
+	if strings.TrimSpace(rest) != "" { // 仅处理位于末尾的源映射，与 goja 的解析规则一致
+		return compiled, ""
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line[strings.Index(line, ",")+1:]))
+	if err != nil {
+		return compiled, ""
+	}
+
+	// goja 将 sources 中的路径相对于源码名称所在的目录解析，因此这里只保留文件名，避免 node_modules/foo 被解析为 node_modules/node_modules/foo.ts
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return compiled, ""
+	}
+	if sources, ok := m["sources"].([]interface{}); ok {
+		for i, v := range sources {
+			if s, ok := v.(string); ok {
+				sources[i] = path.Base(s)
+			}
+		}
+		if data, err = json.Marshal(m); err != nil {
+			return compiled, ""
+		}
+	}
+
+	return compiled[:i] + "//# sourceMappingURL=" + path.Base(name) + ".js.map\n", string(data) // 引用的路径相对于源码名称解析，如 node_modules/foo 对应 node_modules/foo.js.map
+}
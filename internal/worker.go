@@ -1,14 +1,18 @@
 package internal
 
 import (
+	"database/sql"
 	"errors"
-	"io"
-	"net/http"
+	"fmt"
+	"net/url"
 	"path"
 	"strings"
+	"sync"
+	"time"
 
 	"cube/internal/builtin"
 	"cube/internal/cache"
+	"cube/internal/log"
 	m "cube/internal/module"
 
 	"github.com/dop251/goja"
@@ -22,16 +26,53 @@ type Worker struct {
 	defers   []func()
 	loop     *builtin.EventLoop // 事件循环
 	err      error              // 中断异常
+	source   string             // 当前执行的源码，如 "./controller/foo"
+	reqId    string             // 当前处理的请求 ID
+	preview  bool               // 是否预览草稿：加载模块时优先使用未发布的草稿，且不使用常驻的模块实例
+	start    time.Time          // 当前脚本的开始执行时间，未执行时为零值
+	mu       sync.Mutex         // 保护以上执行状态，以便被管理接口并发读取
+
+	modules    *goja.Object                // 本次执行中已加载的模块实例，即 require.cache，每次执行结束后重置
+	persistent map[string]persistentModule // 声明了 module.persistent = true 的模块实例，在 worker 中常驻，直到任一模块的源码变更
+}
+
+type persistentModule struct {
+	module  *goja.Object
+	version uint64 // 模块实例创建时模块缓存的版本号
+}
+
+// WorkerStatus worker 的执行状态
+type WorkerStatus struct {
+	Id        int        `json:"id"`
+	Busy      bool       `json:"busy"`
+	Source    string     `json:"source,omitempty"`
+	Type      string     `json:"type,omitempty"`
+	RequestId string     `json:"request_id,omitempty"`
+	StartTime *time.Time `json:"start_time,omitempty"`
+	Elapsed   int64      `json:"elapsed"` // 已执行的时长，单位毫秒
 }
 
 func (w *Worker) Run(params ...goja.Value) (goja.Value, error) {
-	return w.loop.Run(func() (goja.Value, error) {
-		val, err := w.function(nil, params...)
-		if w.err != nil { // 优先返回 interrupt 的中断信息
-			return val, w.err
-		}
-		return val, err
+	w.mu.Lock()
+	if len(params) > 0 {
+		w.source = params[0].String() // 第一个参数为入口源码的 id，用于日志的来源标记
+	}
+	w.start = time.Now()
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.start = time.Time{}
+		w.mu.Unlock()
+	}()
+
+	val, err := w.loop.Run(func() (goja.Value, error) {
+		return w.function(nil, params...)
 	})
+	if w.err != nil { // 优先返回 interrupt 的中断信息，包括在事件循环中被中断的情况
+		return val, w.err
+	}
+	return val, err
 }
 
 func (w *Worker) Id() int {
@@ -46,6 +87,44 @@ func (w *Worker) EventLoop() *builtin.EventLoop {
 	return w.loop
 }
 
+func (w *Worker) SetRequestId(id string) {
+	w.mu.Lock()
+	w.reqId = id
+	w.mu.Unlock()
+}
+
+// SetPreview 设置本次执行是否预览草稿，须在执行之前调用，执行结束后重置
+func (w *Worker) SetPreview(preview bool) {
+	w.preview = preview
+}
+
+func (w *Worker) RequestId() string {
+	return w.reqId
+}
+
+func (w *Worker) LogFields() log.Fields {
+	f := log.Fields{Worker: w.id, RequestId: w.reqId}
+	if w.source != "" {
+		f.Source, f.Type = parseModuleId(w.source)
+	}
+	return f
+}
+
+func (w *Worker) Status() WorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := WorkerStatus{Id: w.id, RequestId: w.reqId}
+	if w.source != "" {
+		s.Source, s.Type = parseModuleId(w.source)
+	}
+	if !w.start.IsZero() {
+		start := w.start
+		s.Busy, s.StartTime, s.Elapsed = true, &start, time.Since(start).Milliseconds()
+	}
+	return s
+}
+
 func (w *Worker) AddDefer(d func()) {
 	w.defers = append(w.defers, d)
 }
@@ -63,15 +142,15 @@ func (w *Worker) CleanDefers() {
 }
 
 func (w *Worker) Interrupt(reason string) {
+	// 记录中断异常，须在中断事件循环之前记录，否则 Run 方法可能在记录之前返回，导致中断原因丢失
+	w.err = errors.New(reason)
+
 	// 中断事件循环
 	w.loop.Interrupt()
 
 	// 发送中断信号
 	w.Runtime().Interrupt(reason)
 
-	// 记录中断异常
-	w.err = errors.New(reason)
-
 	// 清理句柄
 	w.CleanDefers() // 这里清理句柄，用于防止阻塞，例如监听网络连接：在此时关闭监听器，可以使得监听方法出现异常，可以避免 goja 的中断信号无法被触发问题
 }
@@ -88,120 +167,317 @@ func (w *Worker) Reset() {
 
 	// 重置事件循环
 	w.loop.Reset()
+
+	// 重置已加载的模块实例，常驻的模块实例除外
+	w.modules = w.runtime.NewObject()
+
+	// 退出预览
+	w.preview = false
+
+	// 清理当前执行的源码和请求 ID
+	w.mu.Lock()
+	w.source, w.reqId = "", ""
+	w.mu.Unlock()
 }
 
-func NewProgram() *goja.Program {
-	// 编译源码
-	program, _ := goja.Compile(
-		"index",
-		"(function (id, ...params) { return require(id).default(...params); })", // 使用闭包，防止全局变量污染
-		false, // 关闭严格模式，增加运行时的容错能力
-	)
-	return program
+// 根据 require 的模块 id 解析源码的名称和类型
+func parseModuleId(id string) (name string, stype string) {
+	if strings.HasPrefix(id, "./controller/") {
+		name, stype = id[13:], "controller"
+	} else if strings.HasPrefix(id, "./daemon/") {
+		name, stype = id[9:], "daemon"
+	} else if strings.HasPrefix(id, "./crontab/") {
+		name, stype = id[10:], "crontab"
+	} else if strings.HasPrefix(id, "./") {
+		name, stype = path.Clean(id), "module"
+	} else if strings.HasPrefix(id, "http://") || strings.HasPrefix(id, "https://") {
+		name, stype = id, "link"
+	} else { // 如果没有 "./" 前缀，则视为 node_modules
+		name, stype = "node_modules/"+id, "module"
+	}
+	return
 }
 
-func NewWorker(program *goja.Program, id int) *Worker {
-	runtime := goja.New()
+//#region 模块加载
+
+// 创建 require 方法，相对路径相对于 parent 模块所在的目录解析，parent 为空时相对于根目录解析
+func (w *Worker) newRequire(parent string) *goja.Object {
+	require := w.runtime.ToValue(func(spec string) (goja.Value, error) {
+		return w.requireModule(parent, spec)
+	}).(*goja.Object)
+	require.DefineAccessorProperty("cache", w.runtime.ToValue(func() *goja.Object {
+		return w.modules // 每次执行结束后重置，因此通过访问器获取
+	}), nil, goja.FLAG_FALSE, goja.FLAG_TRUE)
+	require.Set("resolve", func(spec string) (string, error) {
+		return resolveModuleId(parent, spec)
+	})
+	return require
+}
 
-	entry, err := runtime.RunProgram(program) // 这里使用 RunProgram，可复用已编译的代码，相比直接调用 RunString 更显著提升性能
+// 按 CommonJS 规范加载模块：同一次执行中，模块只运行一次，之后返回同一个 module.exports；循环依赖时返回尚未加载完成的 module.exports
+func (w *Worker) requireModule(parent string, spec string) (goja.Value, error) {
+	id, err := resolveModuleId(parent, spec)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	function, ok := goja.AssertFunction(entry)
-	if !ok {
-		panic("program is not a function")
+	if module, ok := w.modules.Get(id).(*goja.Object); ok {
+		return module.Get("exports"), nil
 	}
 
-	worker := Worker{id, runtime, function, make([]func(), 0), builtin.NewEventLoop(), nil}
-
-	runtime.Set("require", func(id string) (goja.Value, error) {
-		program, exists := cache.Module.Get(id)
-		if !exists { // 如果缓存不存在，则查询数据库
-			// 获取名称、类型
-			var name, stype string
-			if strings.HasPrefix(id, "./controller/") {
-				name, stype = id[13:], "controller"
-			} else if strings.HasPrefix(id, "./daemon/") {
-				name, stype = id[9:], "daemon"
-			} else if strings.HasPrefix(id, "./crontab/") {
-				name, stype = id[10:], "crontab"
-			} else if strings.HasPrefix(id, "./") {
-				name, stype = path.Clean(id), "module"
-			} else if strings.HasPrefix(id, "http://") || strings.HasPrefix(id, "https://") {
-				name, stype = id, "link"
-			} else { // 如果没有 "./" 前缀，则视为 node_modules
-				name, stype = "node_modules/"+id, "module"
-			}
+	version := cache.Module.Version() // 须在编译之前获取，以免将编译期间变更的源码对应的模块实例常驻
 
-			var src string
-			if stype == "link" {
-				// 在线请求网络源码
-				resp, err := http.Get(name)
-				if err != nil {
-					return nil, err
-				}
-				defer resp.Body.Close()
-				body, err := io.ReadAll(resp.Body)
-				if err != nil {
-					return nil, err
-				}
-				src = string(body)
-			} else {
-				// 根据名称查找数据库源码
-				if err := Db.QueryRow("select compiled from source where name = ? and type = ? and active = true", name, stype).Scan(&src); err != nil {
-					return nil, err
-				}
-			}
+	compiled, err := compileModule(id, w.preview)
+	if err != nil {
+		return nil, err
+	}
+	if id != compiled.Id { // 如 "./lib" 解析为 "./lib/index"
+		id = compiled.Id
+		if module, ok := w.modules.Get(id).(*goja.Object); ok {
+			return module.Get("exports"), nil
+		}
+	}
 
-			if src == "" {
-				return nil, errors.New("module not found")
-			}
+	if p, ok := w.persistent[id]; ok && !w.preview {
+		if p.version == version {
+			w.modules.Set(id, p.module)
+			return p.module.Get("exports"), nil
+		}
+		delete(w.persistent, id)
+	}
+
+	exports := w.runtime.NewObject()
+	module := w.runtime.NewObject()
+	module.Set("id", id)
+	module.Set("exports", exports)
+	module.Set("loaded", false)
+	module.Set("persistent", false)
+
+	// 在运行之前登记模块实例，以便循环依赖的模块获取到尚未加载完成的 module.exports
+	w.modules.Set(id, module)
+
+	// 运行
+	if err := w.runModule(compiled.Program, id, exports, module); err != nil {
+		w.modules.Delete(id) // 运行失败时移除，以便再次 require 时重新运行
+		return nil, err
+	}
+	module.Set("loaded", true)
 
-			// 编译
-			parsed, err := goja.Parse(
-				name,
-				"(function(exports, require, module) {"+src+"\n})",
-				parser.WithSourceMapLoader(func(p string) ([]byte, error) {
-					return []byte(src), nil
-				}),
-			)
+	if module.Get("persistent").ToBoolean() && !w.preview { // 预览时的模块实例不常驻，以免草稿代码在预览结束后继续运行
+		if w.persistent == nil {
+			w.persistent = make(map[string]persistentModule)
+		}
+		w.persistent[id] = persistentModule{module, version}
+	}
+
+	return module.Get("exports"), nil
+}
+
+func (w *Worker) runModule(program *goja.Program, id string, exports *goja.Object, module *goja.Object) error {
+	entry, err := w.runtime.RunProgram(program)
+	if err != nil {
+		return err
+	}
+	function, ok := goja.AssertFunction(entry)
+	if !ok {
+		return errors.New("entry is not a function")
+	}
+	_, err = function(
+		exports,          // this
+		exports,          // exports
+		w.newRequire(id), // require
+		module,           // module
+	)
+	return err
+}
+
+// 将 require 的参数解析为模块 id：
+//   - 以 "./" 或 "../" 开头的相对路径，相对于 parent 模块所在的目录解析，controller、daemon、crontab 相对于根目录解析，如 "lib/http/retry" 中的 "../date" 解析为 "./lib/date"
+//   - 以 "http://" 或 "https://" 开头的链接，以及链接模块中的相对路径，解析为链接
+//   - 其他视为 node_modules，如 "lodash/fp" 对应 node_modules/lodash/fp
+//
+// 以 ".js"、".cjs" 或 ".json" 结尾时去除扩展名，模块的名称不包含扩展名
+func resolveModuleId(parent string, spec string) (string, error) {
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		return spec, nil
+	}
+
+	if spec != "." && spec != ".." && !strings.HasPrefix(spec, "./") && !strings.HasPrefix(spec, "../") {
+		// 仅去除包内子路径的扩展名，包名本身可能包含 ".js"，如 "bignumber.js"
+		parts := 1
+		if strings.HasPrefix(spec, "@") {
+			parts = 2
+		}
+		if strings.Count(spec, "/") >= parts {
+			spec = trimModuleExt(spec)
+		}
+		return path.Clean(spec), nil
+	}
+	spec = trimModuleExt(spec)
+
+	base := "."
+	if parent != "" {
+		name, stype := parseModuleId(parent)
+		switch stype {
+		case "link":
+			u, err := url.Parse(name)
 			if err != nil {
-				return nil, err
+				return "", err
 			}
-			program, err = goja.CompileAST(parsed, false)
+			ref, err := url.Parse(spec)
 			if err != nil {
-				return nil, err
+				return "", err
 			}
+			return u.ResolveReference(ref).String(), nil
+		case "module":
+			base = path.Dir(name)
+		}
+	}
 
-			// 缓存当前 module 的 program
-			// 这里不应该直接缓存 module，因为 module 依赖当前 vm 实例，在开启多个 vm 实例池的情况下，调用会错乱从而导致异常 "TypeError: Illegal runtime transition of an Object at ..."
-			cache.Module.Add(id, program)
+	name := path.Join(base, spec)
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("cannot resolve module %s from %s", spec, parent)
+	}
+	return moduleId(name), nil
+}
+
+// 去除模块路径的扩展名，模块的名称不包含扩展名
+func trimModuleExt(spec string) string {
+	for _, ext := range []string{".js", ".cjs", ".json"} {
+		if strings.HasSuffix(spec, ext) {
+			return spec[:len(spec)-len(ext)]
 		}
+	}
+	return spec
+}
 
-		exports := runtime.NewObject()
-		module := runtime.NewObject()
-		module.Set("exports", exports)
+// 根据模块的名称获取模块 id，与 parseModuleId 相反
+func moduleId(name string) string {
+	if strings.HasPrefix(name, "node_modules/") {
+		return name[13:]
+	}
+	return "./" + name
+}
 
-		// 运行
-		entry, err := runtime.RunProgram(program)
-		if err != nil {
+// 获取模块编译后的 program，优先从缓存中获取，preview 为 true 时优先使用未发布的草稿编译，并使用单独的缓存
+func compileModule(id string, preview bool) (*cache.CompiledModule, error) {
+	modules := cache.Module
+	if preview {
+		modules = cache.Preview
+	}
+	compiled, exists := modules.Get(id)
+	if exists {
+		return compiled, nil
+	}
+
+	// 如果缓存不存在，则查询数据库
+	// 获取名称、类型
+	name, stype := parseModuleId(id)
+	requested := id
+
+	var src, sourceMap string
+	if stype == "link" {
+		// 远程模块，首次加载时下载并保存到数据库中，之后从数据库中加载
+		var err error
+		if src, err = loadRemoteModule(name); err != nil {
 			return nil, err
 		}
-		if function, ok := goja.AssertFunction(entry); ok {
-			_, err = function(
-				exports,                // this
-				exports,                // exports
-				runtime.Get("require"), // require
-				module,                 // module
-			)
+	} else {
+		// 根据名称查找数据库源码，模块可以省略 index，如 "./lib" 对应 lib 或 lib/index
+		candidates := []string{name}
+		if stype == "module" && path.Base(name) != "index" {
+			candidates = append(candidates, name+"/index")
+		}
+		found := false
+		for _, candidate := range candidates {
+			var lang, content string
+			err := Db.QueryRow("select lang, case when ? and draft then draft_content else content end, case when ? and draft then draft_compiled else compiled end, case when ? and draft then draft_source_map else source_map end from source where name = ? and type = ? and (active = true or ?)", preview, preview, preview, candidate, stype, preview).Scan(&lang, &content, &src, &sourceMap)
+			if err == sql.ErrNoRows {
+				continue
+			}
 			if err != nil {
 				return nil, err
 			}
-		} else {
-			return nil, errors.New("entry is not a function")
+			switch lang {
+			case "json": // JSON 模块导出解析后的值
+				src = "module.exports = " + content + ";"
+			case "javascript": // JavaScript 模块无需编译
+				if src == "" {
+					src = content
+				}
+			}
+			if candidate != name {
+				name, id = candidate, moduleId(candidate)
+			}
+			found = true
+			break
 		}
-		return module.Get("exports"), nil
-	})
+		if !found {
+			return nil, errors.New("module not found: " + id)
+		}
+	}
+
+	if strings.TrimSpace(src) == "" {
+		return nil, errors.New("module not found: " + id)
+	}
+
+	// 编译
+	parsed, err := goja.Parse(
+		name,
+		moduleWrapper+src+"\n})",
+		parser.WithSourceMapLoader(func(p string) ([]byte, error) {
+			if sourceMap == "" { // 没有源映射时，异常堆栈中的位置为编译后代码中的位置
+				return nil, nil
+			}
+			return []byte(sourceMap), nil // 内联的源映射由 goja 直接解析，不会调用该方法
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	program, err := goja.CompileAST(parsed, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// 缓存当前 module 的 program，省略 index 时同时以两个 id 缓存
+	// 这里不应该直接缓存 module，因为 module 依赖当前 vm 实例，在开启多个 vm 实例池的情况下，调用会错乱从而导致异常 "TypeError: Illegal runtime transition of an Object at ..."
+	compiled = &cache.CompiledModule{Id: id, Program: program}
+	modules.Add(id, compiled)
+	if requested != id {
+		modules.Add(requested, compiled)
+	}
+
+	return compiled, nil
+}
+
+//#endregion
+
+func NewProgram() *goja.Program {
+	// 编译源码
+	program, _ := goja.Compile(
+		"index",
+		"(function (id, ...params) { return require(id).default(...params); })", // 使用闭包，防止全局变量污染
+		false, // 关闭严格模式，增加运行时的容错能力
+	)
+	return program
+}
+
+func NewWorker(program *goja.Program, id int) *Worker {
+	runtime := goja.New()
+
+	entry, err := runtime.RunProgram(program) // 这里使用 RunProgram，可复用已编译的代码，相比直接调用 RunString 更显著提升性能
+	if err != nil {
+		panic(err)
+	}
+	function, ok := goja.AssertFunction(entry)
+	if !ok {
+		panic("program is not a function")
+	}
+
+	worker := Worker{id: id, runtime: runtime, function: function, defers: make([]func(), 0), loop: builtin.NewEventLoop()}
+
+	worker.modules = runtime.NewObject()
+	runtime.Set("require", worker.newRequire(""))
 
 	runtime.Set("exports", runtime.NewObject())
 
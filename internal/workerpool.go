@@ -1,7 +1,10 @@
 package internal
 
 import (
+	"time"
+
 	"cube/internal/config"
+	"cube/internal/metrics"
 )
 
 var WorkerPool struct {
@@ -23,3 +26,28 @@ func InitWorkerPool() {
 		WorkerPool.Channels <- worker
 	}
 }
+
+// AcquireWorker 获取空闲的 worker，如果无可用实例，则阻塞等待并记录等待耗时
+func AcquireWorker() *Worker {
+	select {
+	case worker := <-WorkerPool.Channels:
+		return worker
+	default:
+	}
+
+	start := time.Now()
+	worker := <-WorkerPool.Channels
+	metrics.WorkerWaits.Observe(time.Since(start).Seconds())
+	return worker
+}
+
+// TryAcquireWorker 获取空闲的 worker，如果无可用实例，则返回 nil
+func TryAcquireWorker() *Worker {
+	select {
+	case worker := <-WorkerPool.Channels:
+		return worker
+	default:
+		metrics.WorkerRejections.Inc()
+		return nil
+	}
+}
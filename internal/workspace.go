@@ -0,0 +1,341 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"cube/internal/log"
+	"cube/internal/model"
+)
+
+// 源码与目录的同步：每个源码对应一个文件，如 controller/foo.ts、module/lib/date.js，其余属性保存在同名的 .meta.json 文件中，如 controller/foo.meta.json
+
+var (
+	sourceTypes = []string{"controller", "crontab", "daemon", "module", "resource", "template"}
+	sourceExts  = map[string]string{"typescript": ".ts", "tsx": ".tsx", "javascript": ".js", "json": ".json", "html": ".html", "vue": ".vue", "text": ".txt"}
+)
+
+const metaExt = ".meta.json"
+
+// 源码文件的路径，模块名称中的 "/" 对应子目录
+func sourceFile(dir string, source model.Source) string {
+	return filepath.Join(dir, source.Type, filepath.FromSlash(source.Name)+sourceExts[source.Lang])
+}
+
+func metaFile(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + metaExt
+}
+
+// 源码的属性，仅包含与源码类型相关的属性
+func sourceMeta(source model.Source) model.SourceMeta {
+	meta := model.SourceMeta{Active: source.Active, Tag: source.Tag, LastModifiedDate: source.LastModifiedDate.String(), Sha256: digest(source.Content)}
+	switch source.Type {
+	case "controller":
+		meta.Method, meta.Url = source.Method, source.Url
+	case "resource":
+		meta.Url = source.Url
+	case "crontab":
+		meta.Cron, meta.Timeout, meta.Overlap, meta.Retries = source.Cron, source.Timeout, source.Overlap, source.Retries
+	case "daemon":
+		meta.Restart = source.Restart
+	}
+	return meta
+}
+
+// 将属性写入源码，与 sourceMeta 相反
+func applyMeta(source *model.Source, meta model.SourceMeta) {
+	source.Active, source.Tag = meta.Active, meta.Tag
+	switch source.Type {
+	case "controller":
+		source.Method, source.Url = meta.Method, meta.Url
+	case "resource":
+		source.Url = meta.Url
+	case "crontab":
+		source.Cron, source.Timeout, source.Retries = meta.Cron, meta.Timeout, meta.Retries
+		if meta.Overlap != "" {
+			source.Overlap = meta.Overlap
+		}
+	case "daemon":
+		if meta.Restart != "" {
+			source.Restart = meta.Restart
+		}
+	}
+}
+
+// 读取源码的属性，文件不存在时返回 nil
+func readMeta(file string) (*model.SourceMeta, error) {
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta := &model.SourceMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, errors.New(filepath.Base(file) + ": " + err.Error())
+	}
+	return meta, nil
+}
+
+func writeMeta(file string, meta model.SourceMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0o644)
+}
+
+func digest(content string) string {
+	h := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(h[:])
+}
+
+// ExportSources 将已发布的源码导出到目录中，仅写入有变化的文件，不包含草稿
+// 本地文件在上次同步后已修改时不会被覆盖：源码未修改时跳过（等待导入），否则报告冲突，force 为 true 时强制覆盖
+func ExportSources(store SourceStore, dir string, force bool) ([]model.SyncResult, error) {
+	sources, err := store.Sources()
+	if err != nil {
+		return nil, err
+	}
+	results := make([]model.SyncResult, 0, len(sources))
+	for _, source := range sources {
+		results = append(results, exportSource(dir, source, force))
+	}
+	return results, nil
+}
+
+func exportSource(dir string, source model.Source, force bool) model.SyncResult {
+	file := sourceFile(dir, source)
+	path, _ := filepath.Rel(dir, file)
+	result := model.SyncResult{Path: filepath.ToSlash(path), Name: source.Name, Type: source.Type}
+	fail := func(err error) model.SyncResult {
+		result.Action, result.Message = "error", err.Error()
+		return result
+	}
+
+	meta := sourceMeta(source)
+	last, err := readMeta(metaFile(file))
+	if err != nil {
+		return fail(err)
+	}
+	data, err := os.ReadFile(file)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return fail(err)
+	}
+
+	if exists && string(data) != source.Content && !force && (last == nil || digest(string(data)) != last.Sha256) { // 本地文件在上次同步后已修改
+		if last != nil && last.LastModifiedDate == meta.LastModifiedDate {
+			result.Action, result.Message = "skipped", "modified locally, import it first"
+		} else {
+			result.Action, result.Message = "conflict", "modified both locally and in the database since the last sync"
+		}
+		return result
+	}
+	if exists && string(data) == source.Content && last != nil && *last == meta {
+		result.Action = "unchanged"
+		return result
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return fail(err)
+	}
+	// 语言变更后扩展名也随之变化，需要删除原来的文件
+	for _, ext := range sourceExts {
+		if other := strings.TrimSuffix(file, filepath.Ext(file)) + ext; other != file {
+			if err := os.Remove(other); err != nil && !os.IsNotExist(err) {
+				return fail(err)
+			}
+		}
+	}
+	if err := os.WriteFile(file, []byte(source.Content), 0o644); err != nil {
+		return fail(err)
+	}
+	if err := writeMeta(metaFile(file), meta); err != nil {
+		return fail(err)
+	}
+	result.Action = "created"
+	if exists {
+		result.Action = "updated"
+	}
+	return result
+}
+
+// ImportSources 将目录中的源码导入，仅写入有变化的源码，源码将重新编译并校验语法，草稿不受影响
+// 源码在上次同步后已修改时报告冲突，force 为 true 时强制覆盖；目录中不存在的源码不会被删除
+func ImportSources(store SourceStore, dir string, force bool) ([]model.SyncResult, error) {
+	if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, errors.New(dir + " is not a directory")
+	}
+
+	list, err := store.Sources()
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[[2]string]model.Source, len(list))
+	for _, source := range list {
+		sources[[2]string{source.Name, source.Type}] = source
+	}
+
+	results := make([]model.SyncResult, 0)
+	for _, stype := range sourceTypes {
+		seen := make(map[string]string) // 同一个源码只能对应一个文件
+		err := filepath.WalkDir(filepath.Join(dir, stype), func(file string, d fs.DirEntry, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(d.Name(), ".") { // 忽略隐藏的文件和目录，如 .gitkeep
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || strings.HasSuffix(file, metaExt) {
+				return nil
+			}
+
+			path, _ := filepath.Rel(dir, file)
+			name, _ := filepath.Rel(filepath.Join(dir, stype), file)
+			name = filepath.ToSlash(strings.TrimSuffix(name, filepath.Ext(name)))
+			result := model.SyncResult{Path: filepath.ToSlash(path), Name: name, Type: stype}
+
+			lang := ""
+			for l, ext := range sourceExts {
+				if ext == filepath.Ext(file) {
+					lang = l
+				}
+			}
+			if lang == "" {
+				result.Action, result.Message = "skipped", "unknown file extension"
+			} else if other, ok := seen[name]; ok {
+				result.Action, result.Message = "error", "duplicated with "+other
+			} else {
+				seen[name] = result.Path
+				current, exists := sources[[2]string{name, stype}]
+				if !exists {
+					result = importSource(store, file, result, lang, nil, force)
+				} else {
+					result = importSource(store, file, result, lang, &current, force)
+				}
+			}
+			results = append(results, result)
+			return nil
+		})
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+func importSource(store SourceStore, file string, result model.SyncResult, lang string, current *model.Source, force bool) model.SyncResult {
+	fail := func(err error) model.SyncResult {
+		result.Action, result.Message = "error", err.Error()
+		return result
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fail(err)
+	}
+	last, err := readMeta(metaFile(file))
+	if err != nil {
+		return fail(err)
+	}
+
+	// 文件中的源码，与源码类型无关的属性保持不变
+	source := model.Source{Name: result.Name, Type: result.Type, Overlap: "allow", Restart: "never"}
+	if current != nil {
+		source = *current
+	}
+	source.Lang, source.Content = lang, string(data)
+	if last != nil {
+		applyMeta(&source, *last)
+		if err := CheckSourceOptions(source); err != nil { // 无效的 cron 表达式在 watch 模式下将导致调度失败
+			return fail(errors.New(filepath.Base(metaFile(file)) + ": " + err.Error()))
+		}
+	}
+
+	if current != nil {
+		if meta := sourceMeta(*current); source.Lang == current.Lang && sourceMeta(source) == meta {
+			if last == nil || *last != meta { // 仅更新版本号
+				if err := writeMeta(metaFile(file), meta); err != nil {
+					return fail(err)
+				}
+			}
+			result.Action = "unchanged"
+			return result
+		}
+		if !force && (last == nil || last.LastModifiedDate != current.LastModifiedDate.String()) {
+			result.Action, result.Message = "conflict", "modified since the last sync"
+			if last == nil {
+				result.Message = "already exists"
+			}
+			return result
+		}
+	}
+
+	date, err := store.Save(source, current)
+	if err != nil {
+		return fail(err)
+	}
+
+	// 记录同步后的版本号
+	source.LastModifiedDate = date
+	if err := writeMeta(metaFile(file), sourceMeta(source)); err != nil {
+		return fail(err)
+	}
+
+	result.Action = "created"
+	if current != nil {
+		result.Action = "updated"
+	}
+	return result
+}
+
+// WatchSources 在启动时以及此后目录中的文件变化时将源码导入数据库，并刷新缓存，每秒检查一次文件的修改时间和大小
+func WatchSources(dir string) {
+	var last map[string]string
+	for {
+		snapshot := make(map[string]string)
+		filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				if info, err := d.Info(); err == nil {
+					snapshot[file] = info.ModTime().String() + "/" + strconv.FormatInt(info.Size(), 10)
+				}
+			}
+			return nil
+		})
+		if last == nil || !maps.Equal(last, snapshot) {
+			results, err := ImportSources(&DbStore{Refresh: true, User: "watch"}, dir, false)
+			if err != nil {
+				log.Error(log.Fields{Worker: -1}, err)
+			}
+			for _, r := range results {
+				switch r.Action {
+				case "created", "updated":
+					log.Info(log.Fields{Worker: -1, Source: r.Name, Type: r.Type}, r.Path+" "+r.Action)
+				case "conflict", "error":
+					log.Warn(log.Fields{Worker: -1, Source: r.Name, Type: r.Type}, r.Path+" "+r.Action+": "+r.Message)
+				}
+			}
+			last = snapshot
+		}
+		time.Sleep(time.Second)
+	}
+}
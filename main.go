@@ -4,7 +4,9 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"embed"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 
@@ -20,43 +22,75 @@ import (
 //go:embed web/*
 var web embed.FS
 
-func init() {
+func main() {
+	// 解析启动参数
+	flag.Parse()
+
+	// 执行子命令，如 cube export ./src，子命令按需打开数据库，不写入日志文件
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
 	// 初始化数据库
-	internal.InitDb()
+	internal.InitDb("./cube.db")
 
 	// 初始化日志文件
-	log.Init()
+	log.Init(config.LogLevel, config.LogMaxSize, config.LogMaxBackups, config.AccessLog)
+	log.InitBuffer(config.LogBuffer, config.LogBufferPersist)
 
 	// 初始化缓存
-	cache.Init(internal.Db)
+	if err := cache.Init(internal.Db); err != nil {
+		panic(err)
+	}
+
+	// 按启动参数创建 admin 账号
+	if config.IdeAuthorization != "" {
+		if err := internal.EnsureAdmin(config.IdeAuthorization); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
 
 	// 初始化虚拟机池
 	internal.InitWorkerPool()
 
 	// 初始化路由
 	handler.InitHandle(&web)
-}
 
-func main() {
 	// 监控当前进程的内存和 cpu 使用率
 	go internal.RunMonitor()
 
+	// 按保留策略清理源码的历史版本
+	go internal.RunHistoryPruner()
+
 	// 启动守护任务
 	internal.RunDaemons("")
 
+	// 监听目录中源码文件的变化
+	if config.Watch != "" {
+		go internal.WatchSources(config.Watch)
+	}
+
 	// 启动定时服务
 	internal.RunCrontabs("")
 
-	// 启动服务
-	serve()
+	// 启动服务，监听端口后标记服务就绪
+	if err := serve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-func serve() {
+func serve() error {
 	if !config.Secure {
 		// 启用 HTTP
+		ln, err := net.Listen("tcp", ":"+config.Port)
+		if err != nil {
+			return err
+		}
+		internal.Ready.Store(true)
 		fmt.Println("Server has started on http://127.0.0.1:" + config.Port + " 🚀")
-		http.ListenAndServe(":"+config.Port, nil)
-		return
+		return http.Serve(ln, nil)
 	}
 
 	c := &tls.Config{}
@@ -69,22 +103,34 @@ func serve() {
 		c.ClientCAs.AppendCertsFromPEM(b)
 	}
 
-	fmt.Println("Server has started on https://127.0.0.1:" + config.Port + " 🚀")
-
 	if !config.Http3 {
 		// 启用 HTTPS 或 HTTP/2
+		ln, err := net.Listen("tcp", ":"+config.Port)
+		if err != nil {
+			return err
+		}
 		server := &http.Server{
-			Addr:      ":" + config.Port,
 			TLSConfig: c,
 		}
-		server.ListenAndServeTLS(config.ServerCert, config.ServerKey)
-		return
+		internal.Ready.Store(true)
+		fmt.Println("Server has started on https://127.0.0.1:" + config.Port + " 🚀")
+		return server.ServeTLS(ln, config.ServerCert, config.ServerKey)
 	}
 
 	// 启用 HTTP/3
+	cert, err := tls.LoadX509KeyPair(config.ServerCert, config.ServerKey)
+	if err != nil {
+		return err
+	}
+	c.Certificates = []tls.Certificate{cert}
+	conn, err := net.ListenPacket("udp", ":"+config.Port)
+	if err != nil {
+		return err
+	}
 	server := &http3.Server{
-		Addr:      ":" + config.Port,
 		TLSConfig: c,
 	}
-	server.ListenAndServeTLS(config.ServerCert, config.ServerKey)
+	internal.Ready.Store(true)
+	fmt.Println("Server has started on https://127.0.0.1:" + config.Port + " 🚀")
+	return server.Serve(conn)
 }
@@ -0,0 +1,12 @@
+// 启用登录后，接口返回 401 时跳转至登录页面，登录成功后返回当前页面
+(function () {
+    const fetch = window.fetch
+    window.fetch = function () {
+        return fetch.apply(this, arguments).then(r => {
+            if (r.status === 401 && !window.location.pathname.endsWith("/login.html")) {
+                window.location.href = `/login.html?redirect=${encodeURIComponent(window.location.pathname + window.location.search + window.location.hash)}`
+            }
+            return r
+        })
+    }
+})()
@@ -20,6 +20,12 @@ interface ServiceContext {
      * @return body buffer
      */
     getBody(): Buffer;
+    /**
+     * get request id, which is taken from the "X-Request-Id" request header or generated, and is attached to console output and outgoing fetch/http requests
+     * 
+     * @return request id
+     */
+    getRequestId(): string;
     /**
      * get request method
      * 
@@ -29,9 +35,10 @@ interface ServiceContext {
     /**
      * get form data
      * 
+     * @param charset charset of the form parameters, e.g. "gbk", default is utf8
      * @return form object
      */
-    getForm(): { [name: string]: string[]; };
+    getForm(charset?: Charset): { [name: string]: string[]; };
     /**
      * get path variables
      * 
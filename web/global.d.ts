@@ -1,4 +1,11 @@
-type GenericByteArray = string | Uint8Array | Array<number> | Buffer
+type GenericByteArray = string | Uint8Array | Array<number> | Buffer | ArrayBuffer
+
+type BufferEncoding = "utf8" | "utf-8" | "hex" | "base64" | "base64url" | "latin1" | "binary" | "ascii" | "utf16le" | "utf-16le" | "ucs2" | "ucs-2" | Charset
+
+/**
+ * legacy charsets, any WHATWG encoding label is accepted
+ */
+type Charset = "gbk" | "gb18030" | "big5" | "shift_jis" | "euc-jp" | "euc-kr" | "windows-1252" | (string & {})
 
 //#region builtin
 
@@ -7,25 +14,129 @@ declare interface Buffer extends Array<number> {
      * convert buffer to string
      * 
      * @param encoding encoding
+     * @param start start offset, default 0
+     * @param end end offset (exclusive), default buffer.length
      * @return string
      */
-    toString(encoding?: "utf8" | "hex" | "base64" | "base64url"): string;
+    toString(encoding?: BufferEncoding, start?: number, end?: number): string;
     /**
      * parse buffer to json object
      * 
      * @return json object
      */
     toJson(): any;
+    /**
+     * whether this buffer has exactly the same bytes as target
+     */
+    equals(target: GenericByteArray): boolean;
+    /**
+     * compare this buffer with target
+     * 
+     * @return -1, 0 or 1
+     */
+    compare(target: GenericByteArray): -1 | 0 | 1;
+    /**
+     * find the first index of value, or -1 if not found
+     */
+    indexOf(value: string | number | GenericByteArray, byteOffset?: number, encoding?: BufferEncoding): number;
+    lastIndexOf(value: string | number | GenericByteArray, encoding?: BufferEncoding): number;
+    includes(value: string | number | GenericByteArray, byteOffset?: number, encoding?: BufferEncoding): boolean;
+    /**
+     * return a new buffer that references the same memory as the original, negative indexes are counted from the end
+     */
+    slice(start?: number, end?: number): Buffer;
+    subarray(start?: number, end?: number): Buffer;
+    /**
+     * copy bytes from this buffer to target
+     * 
+     * @return number of bytes copied
+     */
+    copy(target: Buffer | Uint8Array, targetStart?: number, sourceStart?: number, sourceEnd?: number): number;
+    /**
+     * fill this buffer with value
+     */
+    fill(value: string | number | GenericByteArray, offset?: number, end?: number, encoding?: BufferEncoding): Buffer;
+    fill(value: string, encoding: BufferEncoding): Buffer;
+    /**
+     * write string to this buffer
+     * 
+     * @return number of bytes written
+     */
+    write(string: string, offset?: number, length?: number, encoding?: BufferEncoding): number;
+    write(string: string, encoding: BufferEncoding): number;
+    readUInt8(offset?: number): number;
+    readUInt16LE(offset?: number): number;
+    readUInt16BE(offset?: number): number;
+    readUInt32LE(offset?: number): number;
+    readUInt32BE(offset?: number): number;
+    readInt8(offset?: number): number;
+    readInt16LE(offset?: number): number;
+    readInt16BE(offset?: number): number;
+    readInt32LE(offset?: number): number;
+    readInt32BE(offset?: number): number;
+    readBigUInt64LE(offset?: number): bigint;
+    readBigUInt64BE(offset?: number): bigint;
+    readBigInt64LE(offset?: number): bigint;
+    readBigInt64BE(offset?: number): bigint;
+    readFloatLE(offset?: number): number;
+    readFloatBE(offset?: number): number;
+    readDoubleLE(offset?: number): number;
+    readDoubleBE(offset?: number): number;
+    /**
+     * the write methods return offset plus the number of bytes written
+     */
+    writeUInt8(value: number, offset?: number): number;
+    writeUInt16LE(value: number, offset?: number): number;
+    writeUInt16BE(value: number, offset?: number): number;
+    writeUInt32LE(value: number, offset?: number): number;
+    writeUInt32BE(value: number, offset?: number): number;
+    writeInt8(value: number, offset?: number): number;
+    writeInt16LE(value: number, offset?: number): number;
+    writeInt16BE(value: number, offset?: number): number;
+    writeInt32LE(value: number, offset?: number): number;
+    writeInt32BE(value: number, offset?: number): number;
+    writeBigUInt64LE(value: bigint, offset?: number): number;
+    writeBigUInt64BE(value: bigint, offset?: number): number;
+    writeBigInt64LE(value: bigint, offset?: number): number;
+    writeBigInt64BE(value: bigint, offset?: number): number;
+    writeFloatLE(value: number, offset?: number): number;
+    writeFloatBE(value: number, offset?: number): number;
+    writeDoubleLE(value: number, offset?: number): number;
+    writeDoubleBE(value: number, offset?: number): number;
 }
 declare interface BufferConstructor {
     /**
-     * convert input to buffer
+     * convert input to buffer, Uint8Array and ArrayBuffer inputs are referenced without copying
      * 
      * @param input input data
      * @param encoding encoding of the input string
      * @return buffer object
      */
-    from(input: GenericByteArray, encoding?: "utf8" | "hex" | "base64" | "base64url"): Buffer;
+    from(input: GenericByteArray, encoding?: BufferEncoding): Buffer;
+    /**
+     * allocate a zero-filled buffer
+     * 
+     * @param size size in bytes
+     * @param fill value to pre-fill the buffer with
+     * @param encoding encoding of the fill string
+     */
+    alloc(size: number, fill?: string | number | GenericByteArray, encoding?: BufferEncoding): Buffer;
+    allocUnsafe(size: number): Buffer;
+    /**
+     * concatenate buffers into a new buffer
+     * 
+     * @param list buffers
+     * @param totalLength total length of the result, default is the sum of the lengths
+     */
+    concat(list: GenericByteArray[], totalLength?: number): Buffer;
+    compare(a: GenericByteArray, b: GenericByteArray): -1 | 0 | 1;
+    isBuffer(value: any): value is Buffer;
+    isEncoding(encoding: string): boolean;
+    byteLength(input: string | GenericByteArray, encoding?: BufferEncoding): number;
+    /**
+     * create an Uint8Array that shares memory with the buffer
+     */
+    toUint8Array(input: Buffer): Uint8Array;
 }
 declare var Buffer: BufferConstructor;
 
@@ -38,6 +149,36 @@ interface Console {
 }
 declare var console: Console;
 
+declare interface Module {
+    /**
+     * module id as passed to require, e.g. "./user" or "lodash"
+     */
+    id: string;
+    exports: any;
+    /**
+     * whether the module body has finished running, false while a circular import is being resolved
+     */
+    loaded: boolean;
+    /**
+     * keep this module instance alive across executions on the same worker, until any module source changes
+     */
+    persistent: boolean;
+}
+
+declare var module: Module;
+
+declare var require: {
+    (id: string): any;
+    /**
+     * resolve a module specifier against the current module, e.g. "../date" in lib/http/retry resolves to "./lib/date"
+     */
+    resolve(id: string): string;
+    /**
+     * modules loaded in the current execution, keyed by module id
+     */
+    cache: { [id: string]: Module };
+};
+
 interface Date {
     /**
      * convert date to string
@@ -190,7 +331,7 @@ declare function clearTimeout(id: TimeoutId): void;
  * @param options options with method, headers and body
  * @return promise resolving to response with status, headers and methods to get buffer, json and text
  */
-declare function fetch(url: string, options?: { method?: "GET" | "POST" | "PUT" | "DELETE"; headers?: { [name: string]: string }; body?: string; }): Promise<{ status: number; headers: { [name: string]: string }; buffer(): Buffer; json(): any; text(): string; }>;
+declare function fetch(url: string, options?: { method?: "GET" | "POST" | "PUT" | "DELETE"; headers?: { [name: string]: string }; body?: string; }): Promise<{ status: number; headers: { [name: string]: string }; buffer(): Buffer; json(): any; text(charset?: Charset): string; }>;
 
 interface WebSocket {
     /**
@@ -224,6 +365,32 @@ declare var WebSocket: {
     new(url: string): WebSocket;
 }
 
+/**
+ * the first parameter of a daemon's default function
+ */
+interface DaemonSignal {
+    "Native Daemon Signal"; /* do not instantiate directly */
+    /**
+     * whether the daemon has been asked to stop, long-running loops should poll it and return
+     */
+    readonly stopped: boolean;
+    /**
+     * register a callback to run when the daemon is asked to stop, it runs immediately if already stopped
+     * 
+     * @param callback callback function
+     * @return void
+     */
+    onStop(callback: () => void): void;
+    /**
+     * handle messages sent by the IDE or other scripts, the return value (or resolved value) is sent back as the reply
+     * registering a handler keeps the daemon running until it is stopped
+     * 
+     * @param handler message handler
+     * @return void
+     */
+    onMessage(handler: (message: any) => any): void;
+}
+
 //#endregion
 
 //#region native module
@@ -466,6 +633,18 @@ declare function $native(name: "crypto"): {
     };
 }
 
+declare function $native(name: "daemon"): {
+    /**
+     * send a message to a running daemon and wait for its reply
+     * 
+     * @param name name of the daemon
+     * @param message message, must be JSON serializable
+     * @param timeout timeout in milliseconds, default 10000
+     * @return reply of the daemon
+     */
+    send(name: string, message?: any, timeout?: number): any;
+}
+
 declare function $native(name: "db"): Database;
 
 declare function $native(name: "email"): (host: string, port: number, username: string, password: string) => {
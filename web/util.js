@@ -0,0 +1,16 @@
+// 将消息中的源码位置（如 foo.ts:5:10）拆分为链接，链接指向编辑器中对应的源码，名称与 source 相同时使用 type 作为源码类型，否则视为模块
+// 返回的链接片段包含 name、line 和 column，其中列号已转换为编辑器中的列号
+function splitMessage({ msg, type, source, }) {
+    const message = String(msg ?? ""),
+        segments = []
+    let i = 0
+    for (const m of message.matchAll(/([\w@.\/-]+?)\.tsx?:(\d+):(\d+)/g)) {
+        const [text, name, line, c] = m,
+            column = Number(c) + 1, // 异常堆栈中的列号从 0 开始，编辑器中的列号从 1 开始
+            position = `${line},${column}`
+        segments.push({ text: message.slice(i, m.index), }, { text, name, line: Number(line), column, href: `/editor.html?name=${encodeURIComponent(name)}&type=${name === source ? type : "module"}#${position}-${position}`, })
+        i = m.index + text.length
+    }
+    segments.push({ text: message.slice(i), })
+    return segments
+}